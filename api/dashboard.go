@@ -58,6 +58,8 @@ type Worker struct {
 	RigID           string
 	Status          string
 	CurrentHashrate HashRate
+	StaleRate       float64
+	DuplicateRate   float64
 	Rating          int16
 	LastSeen        time.Time
 }
@@ -68,6 +70,7 @@ func minerWorkers(poolId, minerId string) []Worker {
 	workerHashRates := minerStats.WorkersReport.Workers
 	workerStats, err := persistence.Miners.GetWorkersLastSeen(poolId, minerId)
 	logOnError(err)
+	staleRates, duplicateRates := workerShareRates(poolId, minerId)
 	var workers []Worker
 	for rigID, worker := range workerStats.Workers {
 		stat, exists := workerHashRates[rigID]
@@ -78,6 +81,8 @@ func minerWorkers(poolId, minerId string) []Worker {
 			RigID:           rigID,
 			Status:          worker.Status,
 			CurrentHashrate: floatToHashrate(stat.Hashrate),
+			StaleRate:       staleRates[rigID],
+			DuplicateRate:   duplicateRates[rigID],
 			// Rating:          0,
 			LastSeen: worker.LastSeen,
 		})
@@ -87,6 +92,22 @@ func minerWorkers(poolId, minerId string) []Worker {
 	return workers
 }
 
+func workerShareRates(poolId, minerId string) (persistence.WorkerShareRateMap, persistence.WorkerShareRateMap) {
+	window, err := time.ParseDuration(serverConfig.HashrateWindow)
+	if err != nil {
+		logOnError(err)
+		return nil, nil
+	}
+
+	now := time.Now()
+	staleRates, err := persistence.Shares.GetWorkerStaleRatesBetween(poolId, minerId, now.Add(-window), now)
+	logOnError(err)
+	duplicateRates, err := persistence.Shares.GetWorkerDuplicateRatesBetween(poolId, minerId, now.Add(-window), now)
+	logOnError(err)
+
+	return staleRates, duplicateRates
+}
+
 func padZeros(balances map[string]float32, chains []string) map[string]float32 {
 	for _, chain := range chains {
 		_, exists := balances[chain]
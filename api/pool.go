@@ -16,6 +16,7 @@ func getPoolIndex(poolID string, chains []string) map[string]any {
 		"PoolHashRate":  floatToHashrate(stat.PoolHashrate),
 		"ActiveMiners":  stat.ConnectedMiners,
 		"Workers":       stat.ConnectedWorkers,
+		"Connections":   stat.Connections,
 		"BlocksPerHour": blocksPerHour(poolID),
 		"LatestBlocks":  recentBlocks(poolID, chains),
 	}
@@ -0,0 +1,253 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Offline address decoding: base58check (P2PKH, P2SH) and bech32/bech32m (P2WPKH, P2WSH, taproot)
+
+type AddressPrefixes struct {
+	PubKeyHash VersionBytes `json:"pubkey_hash"` // Base58 version bytes
+	ScriptHash VersionBytes `json:"script_hash"`
+	Bech32HRP  string       `json:"bech32_hrp"` // Empty for chains without segwit
+}
+
+// Written as a list of numbers in chain definitions, I.e. [48]
+type VersionBytes []byte
+
+func (v *VersionBytes) UnmarshalJSON(data []byte) error {
+	var versions []uint8
+	var numbers []int
+	err := json.Unmarshal(data, &numbers)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if number < 0 || number > 255 {
+			return fmt.Errorf("invalid address version byte: %v", number)
+		}
+		versions = append(versions, uint8(number))
+	}
+	*v = versions
+	return nil
+}
+
+// Output script paying to the address, hex encoded
+func (p AddressPrefixes) PubScriptKey(address string) (string, error) {
+	if p.Bech32HRP != "" && strings.HasPrefix(strings.ToLower(address), p.Bech32HRP+"1") {
+		version, program, err := decodeSegwitAddress(p.Bech32HRP, address)
+		if err != nil {
+			return "", err
+		}
+		opcode := byte(0x00)
+		if version > 0 {
+			opcode = 0x50 + version // OP_1 - OP_16
+		}
+		script := append([]byte{opcode, byte(len(program))}, program...)
+		return hex.EncodeToString(script), nil
+	}
+
+	version, hash, err := decodeBase58Check(address)
+	if err != nil {
+		if _, _, _, bech32Err := decodeBech32(address); bech32Err == nil {
+			return "", errors.New("address is not for this network: " + address) // Another chain's HRP
+		}
+		return "", err
+	}
+	if len(hash) != 20 {
+		return "", errors.New("invalid address length: " + address)
+	}
+
+	if bytes.IndexByte(p.PubKeyHash, version) >= 0 {
+		return "76a914" + hex.EncodeToString(hash) + "88ac", nil // P2PKH
+	}
+	if bytes.IndexByte(p.ScriptHash, version) >= 0 {
+		return "a914" + hex.EncodeToString(hash) + "87", nil // P2SH
+	}
+
+	return "", fmt.Errorf("address version %v is not for this network: %v", version, address)
+}
+
+func (p AddressPrefixes) ValidAddress(address string) bool {
+	_, err := p.PubScriptKey(address)
+	return err == nil
+}
+
+// Decodes the address with the chain's prefixes for the network (main, test or regtest)
+func AddressPubScriptKey(chain Blockchain, network, address string) (string, error) {
+	prefixes, err := chain.AddressPrefixes(network)
+	if err != nil {
+		return "", err
+	}
+	return prefixes.PubScriptKey(address)
+}
+
+func unknownNetwork(chain Blockchain, network string) error {
+	return errors.New("unknown " + chain.ChainName() + " network: " + network)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func decodeBase58(input string) ([]byte, error) {
+	value := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range input {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	leadingZeros := 0
+	for leadingZeros < len(input) && input[leadingZeros] == '1' {
+		leadingZeros++
+	}
+
+	return append(make([]byte, leadingZeros), value.Bytes()...), nil
+}
+
+// Returns the version byte and payload
+func decodeBase58Check(address string) (byte, []byte, error) {
+	decoded, err := decodeBase58(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(decoded) < 5 {
+		return 0, nil, errors.New("base58 address too short: " + address)
+	}
+
+	body, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	sum := sha256.Sum256(body)
+	sum = sha256.Sum256(sum[:])
+	if !bytes.Equal(sum[:4], checksum) {
+		return 0, nil, errors.New("invalid base58 checksum: " + address)
+	}
+
+	return body[0], body[1:], nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32Constant  = 1
+	bech32mConstant = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(value)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// Returns the HRP, the 5 bit data without checksum and the checksum constant it matched
+func decodeBech32(address string) (string, []byte, uint32, error) {
+	if len(address) > 90 {
+		return "", nil, 0, errors.New("bech32 address too long: " + address)
+	}
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return "", nil, 0, errors.New("mixed case bech32 address: " + address)
+	}
+	address = strings.ToLower(address)
+
+	separator := strings.LastIndexByte(address, '1')
+	if separator < 1 || separator+7 > len(address) {
+		return "", nil, 0, errors.New("invalid bech32 separator: " + address)
+	}
+
+	hrp := address[:separator]
+	var data []byte
+	for _, r := range address[separator+1:] {
+		value := strings.IndexRune(bech32Charset, r)
+		if value < 0 {
+			return "", nil, 0, fmt.Errorf("invalid bech32 character %q", r)
+		}
+		data = append(data, byte(value))
+	}
+
+	constant := bech32Polymod(append(bech32HrpExpand(hrp), data...))
+	if constant != bech32Constant && constant != bech32mConstant {
+		return "", nil, 0, errors.New("invalid bech32 checksum: " + address)
+	}
+
+	return hrp, data[:len(data)-6], constant, nil
+}
+
+// 5 bit groups to bytes, BIP173 style without padding
+func convertBits5To8(data []byte) ([]byte, error) {
+	var result []byte
+	accumulator, bits := uint32(0), uint(0)
+	for _, value := range data {
+		accumulator = accumulator<<5 | uint32(value)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			result = append(result, byte(accumulator>>bits))
+		}
+	}
+	if bits >= 5 || (accumulator<<(8-bits))&0xff != 0 {
+		return nil, errors.New("invalid bech32 padding")
+	}
+	return result, nil
+}
+
+// Returns the witness version and program
+func decodeSegwitAddress(hrp, address string) (byte, []byte, error) {
+	decodedHrp, data, constant, err := decodeBech32(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if decodedHrp != hrp {
+		return 0, nil, errors.New("address is not for this network: " + address)
+	}
+	if len(data) < 1 || data[0] > 16 {
+		return 0, nil, errors.New("invalid witness version: " + address)
+	}
+
+	version := data[0]
+	program, err := convertBits5To8(data[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return 0, nil, errors.New("invalid witness program length: " + address)
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return 0, nil, errors.New("invalid witness v0 program length: " + address)
+	}
+	// BIP350, v0 uses bech32 and everything after bech32m
+	if (version == 0 && constant != bech32Constant) || (version > 0 && constant != bech32mConstant) {
+		return 0, nil, errors.New("wrong bech32 variant for witness version: " + address)
+	}
+
+	return version, program, nil
+}
@@ -0,0 +1,317 @@
+package bitcoin
+
+import (
+	"strings"
+	"testing"
+)
+
+// The same hashes encoded for every network.  The bitcoin segwit addresses are the BIP173 and
+// BIP350 examples, the rest were encoded with the chains' prefixes and cross checked outside the pool.
+const (
+	testP2PKHScript   = "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac"
+	testP2WSHScript   = "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"
+	testTaprootScript = "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+)
+
+type addressVector struct {
+	kind    string
+	address string
+	script  string
+}
+
+var addressVectors = map[string]map[string][]addressVector{
+	"bitcoin": {
+		"main": {
+			{"p2pkh", "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH", testP2PKHScript},
+			{"p2sh", "376qjg3yhDrMqJC9m1vg397EyWm2DrYTck", testP2SHScript},
+			{"p2wpkh", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", testP2WPKHScript},
+			{"p2wsh", "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", testP2WSHScript},
+			{"p2tr", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", testTaprootScript},
+		},
+		"test": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", testP2WPKHScript},
+			{"p2wsh", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", testP2WSHScript},
+			{"p2tr", "tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47zagq", testTaprootScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", testP2WPKHScript},
+			{"p2wsh", "bcrt1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qzf4jry", testP2WSHScript},
+			{"p2tr", "bcrt1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqc8gma6", testTaprootScript},
+		},
+	},
+	"litecoin": {
+		"main": {
+			{"p2pkh", "LVuDpNCSSj6pQ7t9Pv6d6sUkLKoqDEVUnJ", testP2PKHScript},
+			{"p2sh", "MDJz3ZTweLhndoU3rtv1rnMeJDMUD2WMiH", testP2SHScript},
+			{"p2sh", "376qjg3yhDrMqJC9m1vg397EyWm2DrYTck", testP2SHScript},
+			{"p2wpkh", "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n9", testP2WPKHScript},
+			{"p2wsh", "ltc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qmu8tk5", testP2WSHScript},
+			{"p2tr", "ltc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqpj6zg2", testTaprootScript},
+		},
+		"test": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "QS1ovRrFKnQoBGak4FaZjnXwLFR1sV3h2m", testP2SHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "tltc1qw508d6qejxtdg4y5r3zarvary0c5xw7klfsuq0", testP2WPKHScript},
+			{"p2wsh", "tltc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qsnr4fp", testP2WSHScript},
+			{"p2tr", "tltc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq2a7uhl", testTaprootScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "QS1ovRrFKnQoBGak4FaZjnXwLFR1sV3h2m", testP2SHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "rltc1qw508d6qejxtdg4y5r3zarvary0c5xw7k693xs3", testP2WPKHScript},
+			{"p2wsh", "rltc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q22ldvf", testP2WSHScript},
+			{"p2tr", "rltc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqsyzyjh", testTaprootScript},
+		},
+	},
+	"dogecoin": {
+		"main": {
+			{"p2pkh", "DFpN6QqFfUm3gKNaxN6tNcab1FArL9cZLE", testP2PKHScript},
+			{"p2sh", "9wr6UX7smHjFjfZdB9b6HGjcg694G3s6KW", testP2SHScript},
+		},
+		"test": {
+			{"p2pkh", "nesRpRaAbTDmZHwmzBkLd2AtF7Z9L9z5S2", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+		},
+	},
+	"vergecoin": {
+		"main": {
+			{"p2pkh", "DFpN6QqFfUm3gKNaxN6tNcab1FArL9cZLE", testP2PKHScript},
+			{"p2sh", "ENZjJiQ3aGptjS6aSmFbcejGbdyS9jv2U3", testP2SHScript},
+		},
+		"test": {
+			{"p2pkh", "oTYdneAk1p9XCADx32QybGiTW852p1CKGT", testP2PKHScript},
+			{"p2sh", "2NmLFmdaaj3HTfx6sUzDBdLe5SsV5ScYRUh", testP2SHScript},
+		},
+		"regtest": {
+			{"p2pkh", "oTYdneAk1p9XCADx32QybGiTW852p1CKGT", testP2PKHScript},
+			{"p2sh", "2NmLFmdaaj3HTfx6sUzDBdLe5SsV5ScYRUh", testP2SHScript},
+		},
+	},
+	"bellscoin": {
+		"main": {
+			{"p2pkh", "BF8MAsLp7aSfb9g9qGSHwzDerit8nAZAJX", testP2PKHScript},
+			{"p2sh", "DAYvMPWBSjSGH8gKNWFeAGuui8Cc2MJ8JC", testP2SHScript},
+			{"p2wpkh", "bel1qw508d6qejxtdg4y5r3zarvary0c5xw7kztdea9", testP2WPKHScript},
+			{"p2wsh", "bel1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qvxzz6d", testP2WSHScript},
+			{"p2tr", "bel1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqkgltyn", testTaprootScript},
+		},
+		"test": {
+			{"p2pkh", "ETqB3jj7o29g8cnr2d6qpzPwtkwgTuXt3Q", testP2PKHScript},
+			{"p2sh", "9wr6UX7smHjFjfZdB9b6HGjcg694G3s6KW", testP2SHScript},
+			{"p2wpkh", "tbel1qw508d6qejxtdg4y5r3zarvary0c5xw7k4ewsw0", testP2WPKHScript},
+			{"p2wsh", "tbel1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q8fxu9c", testP2WSHScript},
+			{"p2tr", "tbel1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqa8m4mx", testTaprootScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "rbel1qw508d6qejxtdg4y5r3zarvary0c5xw7ks40273", testP2WPKHScript},
+			{"p2wsh", "rbel1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qas6yqs", testP2WSHScript},
+			{"p2tr", "rbel1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq878d7w", testTaprootScript},
+		},
+	},
+	"luckycoin": {
+		"main": {
+			{"p2pkh", "L6ZcqFu9jYdwagk4NVmJckCxhpYtTGicG8", testP2PKHScript},
+			{"p2sh", "376qjg3yhDrMqJC9m1vg397EyWm2DrYTck", testP2SHScript},
+		},
+		"test": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+		},
+	},
+	"pepecoin": {
+		"main": {
+			{"p2pkh", "Pic3hEak8AopwazqbGmAysf3NMsNp76pUa", testP2PKHScript},
+			{"p2sh", "9wr6UX7smHjFjfZdB9b6HGjcg694G3s6KW", testP2SHScript},
+		},
+		"test": {
+			{"p2pkh", "nesRpRaAbTDmZHwmzBkLd2AtF7Z9L9z5S2", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+		},
+	},
+	"namecoin": {
+		"main": {
+			{"p2pkh", "N7FdkoPbHSxKfrSVVbRu3NZtrLqc1oKpAR", testP2PKHScript},
+			{"p2sh", "6KofcYSHNfZNNmJqxNbDv9HY1YpZtngRDA", testP2SHScript},
+			{"p2wpkh", "nc1qw508d6qejxtdg4y5r3zarvary0c5xw7kttkktk", testP2WPKHScript},
+			{"p2wsh", "nc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q5r8hjk", testP2WSHScript},
+			{"p2tr", "nc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqwd67vg", testTaprootScript},
+		},
+		"test": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "tn1qw508d6qejxtdg4y5r3zarvary0c5xw7ku7wsq7", testP2WPKHScript},
+			{"p2wsh", "tn1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qrd6us3", testP2WSHScript},
+			{"p2tr", "tn1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqer84w0", testTaprootScript},
+		},
+		"regtest": {
+			{"p2pkh", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", testP2PKHScript},
+			{"p2sh", "2Mxf3oQz1JgMi35phS9YYf66WBryC14VhyG", testP2SHScript},
+			{"p2wpkh", "ncrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kwsfjw6", testP2WPKHScript},
+			{"p2wsh", "ncrt1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qljytf5", testP2WSHScript},
+			{"p2tr", "ncrt1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq9uezh2", testTaprootScript},
+		},
+	},
+}
+
+func TestAddressVectorsCoverEveryChain(t *testing.T) {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+
+	for name, registered := range chains {
+		networks, exists := addressVectors[name]
+		if !exists {
+			t.Errorf("no address vectors for %v", name)
+			continue
+		}
+		for network, prefixes := range registered.(chain).params.Networks {
+			kinds := make(map[string]bool)
+			for _, vector := range networks[network] {
+				kinds[vector.kind] = true
+			}
+
+			want := []string{"p2pkh", "p2sh"}
+			if prefixes.Bech32HRP != "" {
+				want = append(want, "p2wpkh", "p2wsh", "p2tr")
+			}
+			for _, kind := range want {
+				if !kinds[kind] {
+					t.Errorf("no %v address vector for %v %v", kind, name, network)
+				}
+			}
+		}
+	}
+}
+
+func TestAddressPubScriptKey(t *testing.T) {
+	for name, networks := range addressVectors {
+		chain, err := GetChain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for network, vectors := range networks {
+			for _, vector := range vectors {
+				script, err := AddressPubScriptKey(chain, network, vector.address)
+				if err != nil {
+					t.Errorf("%v %v %v %v: %v", name, network, vector.kind, vector.address, err)
+					continue
+				}
+				if script != vector.script {
+					t.Errorf("%v %v %v %v: got %v, want %v", name, network, vector.kind, vector.address, script, vector.script)
+				}
+				if !ValidAddress(chain, network, vector.address) {
+					t.Errorf("%v %v %v %v: not valid", name, network, vector.kind, vector.address)
+				}
+			}
+		}
+	}
+}
+
+func TestAddressPubScriptKeyKnownAddresses(t *testing.T) {
+	tests := []struct {
+		chain   string
+		network string
+		address string
+		script  string
+	}{
+		// Bitcoin's genesis block reward
+		{"bitcoin", "main", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac"},
+		// AntPool's reward in litecoin block 2410822, see coinbase_test.go
+		{"litecoin", "main", "LcNS6c8RddAMjewDrUAAi8BzecKoosnkN3", knownRewardScript},
+		// Upper case bech32 is valid
+		{"bitcoin", "main", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", testP2WPKHScript},
+		{"litecoin", "main", "LTC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KGMN4N9", testP2WPKHScript},
+	}
+
+	for _, test := range tests {
+		chain, err := GetChain(test.chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := AddressPubScriptKey(chain, test.network, test.address)
+		if err != nil {
+			t.Errorf("%v: %v", test.address, err)
+			continue
+		}
+		if script != test.script {
+			t.Errorf("%v: got %v, want %v", test.address, script, test.script)
+		}
+	}
+}
+
+func TestAddressPubScriptKeyRejectsInvalidAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		chain   string
+		network string
+		address string
+		err     string
+	}{
+		{"base58 bad checksum", "litecoin", "main", "LVuDpNCSSj6pQ7t9Pv6d6sUkLKoqDEVUnK", "checksum"},
+		{"bech32 bad checksum", "litecoin", "main", "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n8", "checksum"},
+		{"bech32m bad checksum", "litecoin", "main", "ltc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqpj6zg3", "checksum"},
+		{"mixed case bech32", "litecoin", "main", "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmN4n9", "mixed case"},
+		{"mixed case bitcoin bech32", "bitcoin", "main", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7Kv8f3t4", "mixed case"},
+		{"bitcoin hrp on litecoin", "litecoin", "main", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "not for this network"},
+		{"testnet hrp on mainnet", "litecoin", "main", "tltc1qw508d6qejxtdg4y5r3zarvary0c5xw7klfsuq0", "not for this network"},
+		{"mainnet hrp on testnet", "bellscoin", "test", "bel1qw508d6qejxtdg4y5r3zarvary0c5xw7kztdea9", "not for this network"},
+		{"dogecoin version on litecoin", "litecoin", "main", "DFpN6QqFfUm3gKNaxN6tNcab1FArL9cZLE", "not for this network"},
+		{"testnet version on mainnet", "litecoin", "main", "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", "not for this network"},
+		{"litecoin version on dogecoin", "dogecoin", "main", "LVuDpNCSSj6pQ7t9Pv6d6sUkLKoqDEVUnJ", "not for this network"},
+		{"segwit on a chain without segwit", "dogecoin", "main", "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7kgmn4n9", "not for this network"},
+		// BIP350's v0 with a bech32m checksum
+		{"bitcoin v0 as bech32m", "bitcoin", "main", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", "variant"},
+		{"litecoin v0 as bech32m", "litecoin", "main", "ltc1qw508d6qejxtdg4y5r3zarvary0c5xw7ka8rek8", "variant"},
+		{"bellscoin v0 p2wsh as bech32m", "bellscoin", "main", "bel1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qe6jwl0", "variant"},
+		{"taproot as bech32", "litecoin", "main", "ltc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5w2wdg", "variant"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, err := GetChain(test.chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			script, err := AddressPubScriptKey(chain, test.network, test.address)
+			if err == nil {
+				t.Fatalf("%v accepted as %v", test.address, script)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error %q, want one about %v", err, test.err)
+			}
+			if ValidAddress(chain, test.network, test.address) {
+				t.Fatalf("%v is valid", test.address)
+			}
+		})
+	}
+}
+
+func TestAddressPubScriptKeyUnknownNetwork(t *testing.T) {
+	chain, err := GetChain("litecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AddressPubScriptKey(chain, "signet", "LVuDpNCSSj6pQ7t9Pv6d6sUkLKoqDEVUnJ")
+	if err == nil {
+		t.Fatal("unknown network accepted")
+	}
+}
@@ -1,13 +1,5 @@
 package bitcoin
 
-const (
-	mergedMiningHeader  = "fabe6d6d"
-	merkleTreeSize      = "01000000" // set to 1 if count(auxChains) < 2. Needs research.
-	nonceAndSubchainId  = "00000000" // set to 0 if count(auxChains) < 2. Needs research.
-	parentChainId       = "00002632" // litecoin's chain ID
-	mergedMiningTrailer = "010000000000000000002632"
-)
-
 type AuxBlock struct {
 	Hash              string `json:"hash"`
 	OtherHash         string
@@ -20,7 +12,3 @@ type AuxBlock struct {
 	Target            string `json:"target"`
 	Target2           string `json:"_target"`
 }
-
-func (b *AuxBlock) GetWork() string {
-	return mergedMiningHeader + b.Hash + mergedMiningTrailer
-}
@@ -2,12 +2,17 @@ package bitcoin
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math/bits"
 
 	"designs.capital/dogepool/utils"
 )
 
+// Slots in the merged mining tree the parent coinbase commits to, the auxpow branch must be as deep
+const MergedMiningTreeSize = 16
+
 type AuxMerkleBranch struct {
 	numberOfBranches string
 	branchHashes     []byte
@@ -15,7 +20,7 @@ type AuxMerkleBranch struct {
 }
 
 func makeAuxChainMerkleBranch(b BitcoinBlock, n int) AuxMerkleBranch {
-	merkleBranches, mask, err := buildMerkleBranchesAndMask(8, b.Template.AuxBlocks, n)
+	merkleBranches, mask, err := buildMerkleBranchesAndMask(MergedMiningTreeSize, b.Template.AuxBlocks, n)
 	if err != nil {
 		utils.LogError(err)
 	}
@@ -46,6 +51,7 @@ func getExpectedIndex(nChainId int, h int) uint32 {
 
 func BuildMerkleLeaf(merkleSize int, auxblocks []*AuxBlock) ([][]byte, error) {
 	slots := make([][]byte, merkleSize)
+	height := bits.Len(uint(merkleSize)) - 1
 
 	for i, auxblock := range auxblocks {
 		hash, err := hex.DecodeString(auxblock.Hash)
@@ -55,7 +61,7 @@ func BuildMerkleLeaf(merkleSize int, auxblocks []*AuxBlock) ([][]byte, error) {
 		if slots[i] != nil {
 			utils.LogWarning("Conflit slot when building merkle tree", i, auxblock.ChainID)
 		}
-		slots[getExpectedIndex(auxblock.ChainID, 4)] = utils.ReverseBytes(hash)
+		slots[getExpectedIndex(auxblock.ChainID, height)] = utils.ReverseBytes(hash)
 	}
 
 	// Fill unused slots with arbitrary data (e.g., zeros)
@@ -80,7 +86,7 @@ func buildMerkleBranchesAndMask(merkleSize int, auxblocks []*AuxBlock, n int) ([
 	for idx, hash := range slots {
 		if bytes.Equal(hash, searchedHash) {
 			searchedIndex = idx
-			merkleMask = fmt.Sprintf("0%d000000", idx)
+			merkleMask = hex.EncodeToString(binary.LittleEndian.AppendUint32(nil, uint32(idx)))
 		}
 		currentLevel = append(currentLevel, hash)
 	}
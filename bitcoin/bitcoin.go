@@ -1,5 +1,18 @@
 package bitcoin
 
+// SHA-256d parent for Namecoin style aux chains
+func init() {
+	mustRegisterChain(ChainParams{
+		Name:      "bitcoin",
+		Algorithm: AlgorithmSha256d,
+		Networks: map[string]AddressPrefixes{
+			"main":    {PubKeyHash: []byte{0}, ScriptHash: []byte{5}, Bech32HRP: "bc"},
+			"test":    {PubKeyHash: []byte{111}, ScriptHash: []byte{196}, Bech32HRP: "tb"},
+			"regtest": {PubKeyHash: []byte{111}, ScriptHash: []byte{196}, Bech32HRP: "bcrt"},
+		},
+	})
+}
+
 type BitcoinBlock struct {
 	Template             *Template
 	reversePrevBlockHash string
@@ -9,8 +9,7 @@ type Block interface {
 	Extranonce2SubmissionSlot() (int, bool)
 	ShareMultiplier() float32
 
-	ValidMainnetAddress(address string) bool
-	ValidTestnetAddress(address string) bool
+	ValidAddress(network, address string) bool
 
 	init(Blockchain)
 }
@@ -1,5 +1,14 @@
 package bitcoin
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
 const BitcoinMinConfirmations = 102
 
 type Blockchain interface {
@@ -7,27 +16,176 @@ type Blockchain interface {
 	CoinbaseDigest(coinbase string) (string, error)
 	HeaderDigest(header string) (string, error)
 	ShareMultiplier() float64
+	DifficultyOneTarget() Target
 	MinimumConfirmations() uint
+	AuxPowChainID() uint32
+	CoinbaseVersion() uint32
+	AddressPrefixes(network string) (AddressPrefixes, error) // main, test or regtest
+}
+
+const (
+	AlgorithmScrypt  = "scrypt"
+	AlgorithmSha256d = "sha256d"
+)
+
+// Everything that sets a coin apart from the rest of the bitcoin family.
+// Built in chains register themselves, forks can be defined in a chain definitions file.
+type ChainParams struct {
+	Name                 string                     `json:"name"`
+	Algorithm            string                     `json:"algorithm"`             // scrypt or sha256d
+	ShareMultiplier      float64                    `json:"share_multiplier"`      // Defaults to the algorithm's
+	Diff1Target          string                     `json:"diff1_target"`          // Hex, defaults to the algorithm's
+	MinimumConfirmations uint                       `json:"minimum_confirmations"` // Defaults to 102
+	AuxPowChainID        uint32                     `json:"auxpow_chain_id"`       // As an aux chain, if its daemon doesn't send one
+	CoinbaseVersion      uint32                     `json:"coinbase_version"`      // Defaults to 1
+	Networks             map[string]AddressPrefixes `json:"networks"`              // main, test and regtest
+}
+
+type chain struct {
+	params ChainParams
+}
+
+var (
+	chains    = make(map[string]Blockchain)
+	chainLock sync.RWMutex
+)
+
+func defaultShareMultiplier(algorithm string) float64 {
+	if algorithm == AlgorithmScrypt {
+		return 65536
+	}
+	return 1
+}
+
+func defaultDiff1Target(algorithm string) string {
+	if algorithm == AlgorithmScrypt {
+		return scryptDiff1Target
+	}
+	return highestTarget
+}
+
+// Later registrations replace earlier ones, so a definitions file can override a built in chain
+func RegisterChain(params ChainParams) error {
+	if params.Name == "" {
+		return errors.New("chain definition needs a name")
+	}
+	if params.Algorithm != AlgorithmScrypt && params.Algorithm != AlgorithmSha256d {
+		return fmt.Errorf("unsupported %v algorithm: %v", params.Name, params.Algorithm)
+	}
+	if len(params.Networks) == 0 {
+		return errors.New(params.Name + " needs address prefixes for at least one network")
+	}
+	if params.ShareMultiplier <= 0 {
+		params.ShareMultiplier = defaultShareMultiplier(params.Algorithm)
+	}
+	if params.Diff1Target == "" {
+		params.Diff1Target = defaultDiff1Target(params.Algorithm)
+	}
+	diff1Target := Target(params.Diff1Target)
+	_, validTarget := diff1Target.ToBig()
+	if !validTarget {
+		return fmt.Errorf("invalid %v diff1_target: %v", params.Name, params.Diff1Target)
+	}
+	if params.MinimumConfirmations == 0 {
+		params.MinimumConfirmations = BitcoinMinConfirmations
+	}
+	if params.CoinbaseVersion == 0 {
+		params.CoinbaseVersion = 1
+	}
+
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	chains[params.Name] = chain{params}
+
+	return nil
+}
+
+func mustRegisterChain(params ChainParams) {
+	err := RegisterChain(params)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func GetChain(chainName string) (Blockchain, error) {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+
+	chain, exists := chains[chainName]
+	if !exists {
+		return nil, errors.New("unknown blockchain: " + chainName)
+	}
+	return chain, nil
+}
+
+// JSON array of ChainParams
+func LoadChainDefinitions(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	var definitions []ChainParams
+	err = json.Unmarshal(fileBytes, &definitions)
+	if err != nil {
+		return err
+	}
+
+	for _, params := range definitions {
+		err = RegisterChain(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c chain) ChainName() string {
+	return c.params.Name
+}
+
+func (c chain) CoinbaseDigest(coinbase string) (string, error) {
+	return DoubleSha256(coinbase)
+}
+
+func (c chain) HeaderDigest(header string) (string, error) {
+	if c.params.Algorithm == AlgorithmSha256d {
+		return DoubleSha256(header)
+	}
+	return ScryptDigest(header)
+}
+
+func (c chain) ShareMultiplier() float64 {
+	return c.params.ShareMultiplier
+}
+
+func (c chain) DifficultyOneTarget() Target {
+	return Target(c.params.Diff1Target)
+}
+
+func (c chain) MinimumConfirmations() uint {
+	return c.params.MinimumConfirmations
+}
+
+func (c chain) AuxPowChainID() uint32 {
+	return c.params.AuxPowChainID
+}
+
+func (c chain) CoinbaseVersion() uint32 {
+	return c.params.CoinbaseVersion
+}
 
-	ValidMainnetAddress(address string) bool
-	ValidTestnetAddress(address string) bool
-}
-
-func GetChain(chainName string) Blockchain {
-	switch chainName {
-	case "dogecoin":
-		return Dogecoin{}
-	case "litecoin":
-		return Litecoin{}
-	case "bellscoin":
-		return Bellscoin{}
-	case "luckycoin":
-		return Luckycoin{}
-	case "pepecoin":
-		return Pepecoin{}
-	case "vergecoin":
-		return Vergecoin{}
-	default:
-		panic("Unknown blockchain: " + chainName)
+func (c chain) AddressPrefixes(network string) (AddressPrefixes, error) {
+	prefixes, exists := c.params.Networks[network]
+	if !exists {
+		return AddressPrefixes{}, unknownNetwork(c, network)
 	}
+	return prefixes, nil
 }
@@ -1,13 +1,12 @@
 package bitcoin
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 
 	"designs.capital/dogepool/utils"
-	"golang.org/x/crypto/ripemd160"
 )
 
 // https://developer.bitcoin.org/reference/transactions.html#coinbase-input-the-input-of-the-first-transaction-in-a-block
@@ -48,7 +47,7 @@ func encodeNumber(n uint) []byte {
 	return buff[:l]
 }
 
-func (t *Template) CoinbaseInitial(arbitraryByteLength uint) CoinbaseInital {
+func (t *Template) CoinbaseInitial(version uint32, arbitraryByteLength uint) CoinbaseInital {
 	// heightBytes := eightLittleEndianBytes(t.Height)
 	// heightBytes = removeInsignificantBytesLittleEndian(heightBytes)
 	heightBytes := encodeNumber(t.Height)
@@ -63,7 +62,7 @@ func (t *Template) CoinbaseInitial(arbitraryByteLength uint) CoinbaseInital {
 	}
 
 	return CoinbaseInital{
-		Version:                     "01000000", // Different from template version
+		Version:                     hex.EncodeToString(fourLittleEndianBytes(version)), // Different from template version
 		NumberOfInputs:              "01",
 		PreviousOutputTransactionID: "0000000000000000000000000000000000000000000000000000000000000000",
 		PreviousOutputIndex:         "ffffffff",
@@ -91,15 +90,18 @@ type CoinbaseFinal struct {
 	TransactionLockTime   string
 }
 
-func (t *Template) CoinbaseFinal(poolPayoutPubScriptKey string) CoinbaseFinal {
-	txOutputLen, txOutput := t.coinbaseTransactionOutputs(poolPayoutPubScriptKey)
+func (t *Template) CoinbaseFinal(poolPayoutPubScriptKey string, recipients []CoinbaseRecipient) (CoinbaseFinal, error) {
+	txOutputLen, txOutput, err := t.coinbaseTransactionOutputs(poolPayoutPubScriptKey, recipients)
+	if err != nil {
+		return CoinbaseFinal{}, err
+	}
 	utils.LogInfof("Nombre de txOutput coinbaseFinal %d", txOutputLen)
 	return CoinbaseFinal{
 		TransactionInSequence: "00000000",
 		OutputCount:           txOutputLen,
 		TxOuts:                txOutput,
 		TransactionLockTime:   "00000000",
-	}
+	}, nil
 }
 
 func (f CoinbaseFinal) Serialize() string {
@@ -121,56 +123,56 @@ func (cb *Coinbase) Serialize() string {
 	return cb.CoinbaseInital + cb.Arbitrary + cb.CoinbaseFinal
 }
 
-func GeneratePubKeyScript(pubKey string) (string, error) {
-	pubKeyBytes, err := hex.DecodeString(pubKey)
-	if err != nil {
-		return "", err
-	}
-
-	// Étape 1 : Hachage SHA-256
-	shaHash := sha256.Sum256(pubKeyBytes)
-
-	// Étape 2 : Hachage RIPEMD-160
-	ripemdHasher := ripemd160.New()
-	_, err = ripemdHasher.Write(shaHash[:])
-	if err != nil {
-		return "", err
-	}
-	pubKeyHash := ripemdHasher.Sum(nil)
-
-	// Construire le script
-	script := "76a914" + hex.EncodeToString(pubKeyHash) + "88ac" // Script P2PKH
-	return script, nil
+// Fixed share of the block reward, I.e. a pool fee or dev fund
+type CoinbaseRecipient struct {
+	PubScriptKey string
+	Percentage   float64 // 0.01 = 1%
 }
 
-func (t *Template) coinbaseTransactionOutputs(poolPubScriptKey string) (uint, string) {
+// Recipients get their percentage rounded down, reward_to gets the rest.
+// Outputs always add up to exactly CoinBaseValue.
+func (t *Template) coinbaseTransactionOutputs(poolPubScriptKey string, recipients []CoinbaseRecipient) (uint, string, error) {
 	outputsCount := uint(0)
 	outputs := ""
 
+	if poolPubScriptKey == "" {
+		return 0, "", errors.New("coinbase needs a reward script")
+	}
+
 	if t.DefaultWitnessCommitment != "" {
 		outAmount := "0000000000000000"
 		outputs = outputs + TransactionOut(outAmount, t.DefaultWitnessCommitment)
 		outputsCount++
 	}
 
-	// Some alt coins may have additional outputs..
-
-	// Pool reward output
-	rewardAmount := fmt.Sprintf("%016x", t.CoinBaseValue)
-	rewardAmount, _ = reverseHexBytes(rewardAmount)
-	pubScriptKey, err := GeneratePubKeyScript(poolPubScriptKey)
-	if err != nil {
-		panic("Erreur lors de la génération du script de clé publique : " + err.Error())
+	recipientOutputs := ""
+	remainder := uint64(t.CoinBaseValue)
+	for _, recipient := range recipients {
+		if recipient.Percentage <= 0 || recipient.PubScriptKey == "" {
+			return 0, "", fmt.Errorf("invalid coinbase recipient %+v", recipient)
+		}
+		amount := uint64(float64(t.CoinBaseValue) * recipient.Percentage)
+		if amount > remainder {
+			return 0, "", errors.New("coinbase recipients add up to more than the block reward")
+		}
+		remainder -= amount
+
+		recipientOutputs = recipientOutputs + TransactionOut(coinbaseAmount(amount), recipient.PubScriptKey)
+		outputsCount++
 	}
 
-	outputs = outputs + TransactionOut(rewardAmount, pubScriptKey)
-	outputsCount++
-	outputs = outputs + TransactionOut(rewardAmount, poolPubScriptKey)
-	outputsCount++
-	outputs = outputs + TransactionOut(rewardAmount, "AAAA")
+	// Pool reward output
+	outputs = outputs + TransactionOut(coinbaseAmount(remainder), poolPubScriptKey)
 	outputsCount++
+	outputs = outputs + recipientOutputs
+
+	return outputsCount, outputs, nil
+}
 
-	return outputsCount, outputs
+// Little endian, 8 bytes
+func coinbaseAmount(amount uint64) string {
+	littleEndian, _ := reverseHexBytes(fmt.Sprintf("%016x", amount))
+	return littleEndian
 }
 
 // func debugCoinbaseOutput(cb *Coinbase) {
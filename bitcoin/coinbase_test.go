@@ -0,0 +1,258 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Parent coinbase of litecoin block 2410822 (AntPool), from the auxpow of dogecoin block 4569590
+// in docs/auxblock_example.txt.  TestKnownCoinbaseIsLitecoinBlock2410822 proves it's the real one.
+const (
+	knownCoinbase = "01000000" + "01" +
+		"0000000000000000000000000000000000000000000000000000000000000000" + "ffffffff" +
+		"54" + "0346c924" + "1b4d696e656420627920416e74506f6f6c363332d4001502232a7aba" +
+		"fabe6d6dbd53c430265a2ed3f9774c53d69a8beedafd945b7ce2cad70b6543044983d507010000000000000000002632b6000000" +
+		"ffffffff" + "02" +
+		knownRewardOutput +
+		knownWitnessCommitmentOutput +
+		"00000000"
+	knownMerkleRoot = "b1f3a017ed1973ae0f58e6925c2bd51607abfde6c1ef4e07fa0065a8d74353ac"
+
+	knownHeight                  = 2410822
+	knownHeightPush              = "0346c924"
+	knownCoinbaseValue           = 1255002262
+	knownRewardScript            = "76a914bc14b37375146785074671bbaf5804133e5827e588ac"
+	knownRewardOutput            = "96d0cd4a00000000" + "19" + knownRewardScript
+	knownWitnessCommitment       = "6a24aa21a9ed55e0c30fb8122dc432e9aa74131fa031d952cd062b6c7633a238f75ef292b09f"
+	knownWitnessCommitmentOutput = "0000000000000000" + "26" + knownWitnessCommitment
+)
+
+var knownMerkleBranch = []string{
+	"24618e1d4274e6bf29c9f3ab22820cdfe6acca0cebe4089359b16badc5db688f",
+	"0549d9713d81c77ceffee2085513cdd52544af3225ad27a6b1832eb2eef3091a",
+	"0d13190e1d96ca7ad232b0aeede57d3f9a0c40e00fbf3186581c8143f9adcf6c",
+	"7fdaefd456b6a986757e1ba1d08035810e84683533da5ac9ab13a3f16bd7cb7c",
+	"51b1755aa6c754cce36cc73f10e13d58304c88df5e4be42cc8618f43dc87a555",
+	"5f7eca4a741f5fbb925d16f005da681e5ef0e4895d90ad4b20a062d699a13d4d",
+	"2828fe124644509c362d0989af3c5394cc20ca7b767c1df9ff7469c156ef301d",
+	"c5026f450caa6d37b9340818adec6ade3f24e6164b4fa6afcf55386b4f72a78d",
+	"39fab2a32d6d79e844b704482629848a6f8f6afee3e81bf4e5f2d1b4739a7d7f",
+}
+
+const (
+	testSignature    = "Mined by AntPool632"
+	testExtranonce   = "d4001502232a7aba" // AntPool's, 8 bytes like the pool reserves
+	testP2SHScript   = "a9143b5a1ba4b9bd3b8f2ee1a49c2e0c8d3b4c5d6e7f87"
+	testP2WPKHScript = "0014751e76e8199196d454941c45d1b3a323f1433bd6"
+)
+
+func TestKnownCoinbaseIsLitecoinBlock2410822(t *testing.T) {
+	txid, err := DoubleSha256(knownCoinbase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := makeHeaderMerkleRoot(txid, knownMerkleBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != knownMerkleRoot {
+		t.Fatalf("merkle root: got %v, want %v", root, knownMerkleRoot)
+	}
+}
+
+func knownTemplate(witnessCommitment string) *Template {
+	return &Template{
+		Version:                  0x20000000,
+		PrevBlockHash:            "85f2589e7ab172e359ce9e57789b4ba750090a2e8f571d64decdd53370366adf",
+		Height:                   knownHeight,
+		CoinBaseValue:            knownCoinbaseValue,
+		DefaultWitnessCommitment: witnessCommitment,
+		Bits:                     "1a00bf3b",
+		CurrentTime:              0x63d13b56,
+	}
+}
+
+// Serialized coinbase for a job, the way a miner assembles it
+func generateCoinbase(t *testing.T, template *Template, recipients []CoinbaseRecipient) string {
+	t.Helper()
+
+	block, work, err := GenerateWork(template, "litecoin", testSignature, knownRewardScript, recipients, len(testExtranonce)/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = block.MakeHeader(testExtranonce, "00000000", work[7].(string), template.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.coinbase != work[2].(string)+testExtranonce+work[3].(string) {
+		t.Fatal("MakeHeader's coinbase differs from the job's coinbase parts")
+	}
+
+	return block.coinbase
+}
+
+// The pool's coinbase carries the same fields as the known block's, in the pool's layout:
+// signature pushed after the extranonce, sequence 0, and the witness commitment first.
+func poolLayoutCoinbase(outputCount string, outputs ...string) string {
+	scriptSig := knownHeightPush + testExtranonce + "13" + hex.EncodeToString([]byte(testSignature))
+	return "01000000" + "01" +
+		"0000000000000000000000000000000000000000000000000000000000000000" + "ffffffff" +
+		"20" + scriptSig +
+		"00000000" + outputCount + strings.Join(outputs, "") +
+		"00000000"
+}
+
+func TestGenerateWorkCoinbaseMatchesKnownBlock(t *testing.T) {
+	for _, piece := range []string{knownHeightPush, knownRewardOutput, knownWitnessCommitmentOutput} {
+		if !strings.Contains(knownCoinbase, piece) {
+			t.Fatalf("%v is not part of the known coinbase", piece)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		witnessCommitment string
+		recipients        []CoinbaseRecipient
+		want              string
+	}{
+		{
+			name:              "default_witness_commitment",
+			witnessCommitment: knownWitnessCommitment,
+			want:              poolLayoutCoinbase("02", knownWitnessCommitmentOutput, knownRewardOutput),
+		},
+		{
+			name: "no witness commitment",
+			want: poolLayoutCoinbase("01", knownRewardOutput),
+		},
+		{
+			name:              "coinbase_outputs",
+			witnessCommitment: knownWitnessCommitment,
+			recipients: []CoinbaseRecipient{
+				{PubScriptKey: testP2SHScript, Percentage: 0.01},    // 12550022.62 rounded down
+				{PubScriptKey: testP2WPKHScript, Percentage: 0.015}, // 18825033.93 rounded down
+			},
+			want: poolLayoutCoinbase("04",
+				knownWitnessCommitmentOutput,
+				"c711ef4800000000"+"19"+knownRewardScript, // 1223627207, the remainder
+				"867fbf0000000000"+"17"+testP2SHScript,
+				"493f1f0100000000"+"16"+testP2WPKHScript,
+			),
+		},
+		{
+			name: "coinbase_outputs without witness commitment",
+			recipients: []CoinbaseRecipient{
+				{PubScriptKey: testP2SHScript, Percentage: 0.01},
+			},
+			want: poolLayoutCoinbase("02",
+				"10510e4a00000000"+"19"+knownRewardScript, // 1242452240
+				"867fbf0000000000"+"17"+testP2SHScript,
+			),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := generateCoinbase(t, knownTemplate(test.witnessCommitment), test.recipients)
+			if got != test.want {
+				t.Fatalf("coinbase:\n got %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
+
+type coinbaseOutput struct {
+	amount uint64
+	script string
+}
+
+// Outputs of a serialized coinbase with a single byte script length, like the pool's
+func parseCoinbaseOutputs(t *testing.T, coinbase string) []coinbaseOutput {
+	t.Helper()
+
+	raw, err := hex.DecodeString(coinbase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := 4 + 1 + 36 // Version, input count, previous output
+	position += 1 + int(raw[position]) + 4
+	count := int(raw[position])
+	position++
+
+	outputs := make([]coinbaseOutput, count)
+	for i := range outputs {
+		outputs[i].amount = binary.LittleEndian.Uint64(raw[position : position+8])
+		scriptLength := int(raw[position+8])
+		outputs[i].script = hex.EncodeToString(raw[position+9 : position+9+scriptLength])
+		position += 9 + scriptLength
+	}
+	if position+4 != len(raw) {
+		t.Fatalf("%v bytes left after the outputs", len(raw)-position)
+	}
+
+	return outputs
+}
+
+func TestCoinbaseOutputsAddUpWhenRoundingDown(t *testing.T) {
+	tests := []struct {
+		value       uint
+		percentages []float64
+	}{
+		{knownCoinbaseValue, []float64{0.01}},
+		{knownCoinbaseValue, []float64{0.01, 0.015, 0.0033}},
+		{1000000007, []float64{1.0 / 3, 1.0 / 3}},
+		{999999999, []float64{0.07, 0.29, 0.001}},
+		{7, []float64{0.5, 0.25}},
+		{1, []float64{0.5}},
+		{10000, []float64{0.5, 0.5}},
+	}
+
+	scripts := []string{testP2SHScript, testP2WPKHScript, knownRewardScript}
+	for _, test := range tests {
+		template := knownTemplate(knownWitnessCommitment)
+		template.CoinBaseValue = test.value
+
+		var recipients []CoinbaseRecipient
+		for i, percentage := range test.percentages {
+			recipients = append(recipients, CoinbaseRecipient{PubScriptKey: scripts[i], Percentage: percentage})
+		}
+
+		outputs := parseCoinbaseOutputs(t, generateCoinbase(t, template, recipients))
+		if len(outputs) != len(recipients)+2 {
+			t.Fatalf("%v %v: got %v outputs, want %v", test.value, test.percentages, len(outputs), len(recipients)+2)
+		}
+		if outputs[0].amount != 0 || outputs[0].script != knownWitnessCommitment {
+			t.Fatalf("%v %v: first output isn't the witness commitment: %+v", test.value, test.percentages, outputs[0])
+		}
+
+		total := uint64(0)
+		for _, output := range outputs {
+			total += output.amount
+		}
+		if total != uint64(test.value) {
+			t.Fatalf("%v %v: outputs add up to %v", test.value, test.percentages, total)
+		}
+
+		for i, recipient := range recipients {
+			exact := float64(test.value) * recipient.Percentage
+			amount := float64(outputs[i+2].amount)
+			if amount > exact || amount <= exact-1 {
+				t.Fatalf("%v %v: recipient %v got %v, want %v rounded down", test.value, test.percentages, i, amount, exact)
+			}
+		}
+	}
+}
+
+func TestCoinbaseOutputsRejectOverpayingRecipients(t *testing.T) {
+	template := knownTemplate("")
+	recipients := []CoinbaseRecipient{
+		{PubScriptKey: testP2SHScript, Percentage: 0.6},
+		{PubScriptKey: testP2WPKHScript, Percentage: 0.6},
+	}
+
+	_, _, err := GenerateWork(template, "litecoin", testSignature, knownRewardScript, recipients, 8)
+	if err == nil {
+		t.Fatal("recipients taking 120% of the reward were accepted")
+	}
+}
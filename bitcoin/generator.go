@@ -16,7 +16,7 @@ type BlockGenerator interface {
 
 var jobCounter int
 
-func GenerateWork(template *Template, chainName, arbitrary, poolPayoutPubScriptKey string, reservedArbitraryByteLength int) (*BitcoinBlock, Work, error) { // On trigger
+func GenerateWork(template *Template, chainName, arbitrary, poolPayoutPubScriptKey string, recipients []CoinbaseRecipient, reservedArbitraryByteLength int) (*BitcoinBlock, Work, error) { // On trigger
 	if template == nil {
 		return nil, nil, errors.New("Template cannot be null")
 	}
@@ -24,7 +24,11 @@ func GenerateWork(template *Template, chainName, arbitrary, poolPayoutPubScriptK
 	var err error
 	block := BitcoinBlock{}
 
-	block.init(GetChain(chainName))
+	chain, err := GetChain(chainName)
+	if err != nil {
+		return nil, nil, err
+	}
+	block.init(chain)
 	block.Template = template
 
 	block.reversePrevBlockHash, err = reverseHex4Bytes(block.Template.PrevBlockHash)
@@ -37,8 +41,12 @@ func GenerateWork(template *Template, chainName, arbitrary, poolPayoutPubScriptK
 	arbitraryByteLength := uint(len(arbitraryBytes) + reservedArbitraryByteLength)
 	arbitraryHex := hex.EncodeToString(arbitraryBytes)
 
-	block.coinbaseInitial = block.Template.CoinbaseInitial(arbitraryByteLength).Serialize()
-	block.coinbaseFinal = arbitraryHex + block.Template.CoinbaseFinal(poolPayoutPubScriptKey).Serialize()
+	block.coinbaseInitial = block.Template.CoinbaseInitial(chain.CoinbaseVersion(), arbitraryByteLength).Serialize()
+	coinbaseFinal, err := block.Template.CoinbaseFinal(poolPayoutPubScriptKey, recipients)
+	if err != nil {
+		return nil, nil, err
+	}
+	block.coinbaseFinal = arbitraryHex + coinbaseFinal.Serialize()
 	block.merkleSteps, err = block.Template.MerkleSteps()
 	if err != nil {
 		return nil, nil, err
@@ -59,7 +67,8 @@ func GenerateWork(template *Template, chainName, arbitrary, poolPayoutPubScriptK
 	return &block, work, nil
 }
 
-func (b *BitcoinBlock) MakeHeader(extranonce, nonce, nonceTime string) (string, error) {
+// Version is the template's version, unless the miner rolled it (BIP310)
+func (b *BitcoinBlock) MakeHeader(extranonce, nonce, nonceTime string, version uint) (string, error) {
 	if b.Template == nil {
 		return "", errors.New("generate work first")
 	}
@@ -84,7 +93,7 @@ func (b *BitcoinBlock) MakeHeader(extranonce, nonce, nonceTime string) (string,
 
 	t := b.Template
 
-	b.header, err = blockHeader(uint(t.Version), t.PrevBlockHash, merkleRoot, nonceTime, t.Bits, nonce)
+	b.header, err = blockHeader(version, t.PrevBlockHash, merkleRoot, nonceTime, t.Bits, nonce)
 
 	if err != nil {
 		return "", err
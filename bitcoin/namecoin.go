@@ -0,0 +1,14 @@
+package bitcoin
+
+func init() {
+	mustRegisterChain(ChainParams{
+		Name:          "namecoin",
+		Algorithm:     AlgorithmSha256d,
+		AuxPowChainID: 0x01,
+		Networks: map[string]AddressPrefixes{
+			"main":    {PubKeyHash: []byte{52}, ScriptHash: []byte{13}, Bech32HRP: "nc"},
+			"test":    {PubKeyHash: []byte{111}, ScriptHash: []byte{196}, Bech32HRP: "tn"},
+			"regtest": {PubKeyHash: []byte{111}, ScriptHash: []byte{196}, Bech32HRP: "ncrt"},
+		},
+	})
+}
@@ -11,7 +11,10 @@ import (
 
 type Target string
 
-const highestTarget = "00000000ffff0000000000000000000000000000000000000000000000000000"
+const (
+	highestTarget     = "00000000ffff0000000000000000000000000000000000000000000000000000"
+	scryptDiff1Target = "0000ffff00000000000000000000000000000000000000000000000000000000"
+)
 
 func (t *Target) ToBig() (*big.Int, bool) {
 	return new(big.Int).SetString(string(*t), 16)
@@ -35,9 +38,14 @@ func (t *Target) ToDifficulty() (float64, big.Accuracy) {
 }
 
 func TargetFromDifficulty(difficulty float64) (Target, big.Accuracy) {
-	highestTargetBig, success := new(big.Int).SetString(highestTarget, 16)
+	return targetFromDifficulty(highestTarget, difficulty)
+}
+
+// Difficulty relative to a chain's own difficulty 1 target
+func targetFromDifficulty(diff1Target Target, difficulty float64) (Target, big.Accuracy) {
+	highestTargetBig, success := diff1Target.ToBig()
 	if !success {
-		panic("Failed to convert highest target value to big int")
+		panic("Failed to convert difficulty 1 target value to big int")
 	}
 	highestTargetBigFloat := new(big.Float).SetInt(highestTargetBig)
 
@@ -19,3 +19,23 @@ type Template struct {
 	MimbleWimble             string        `json:"mweb"`
 	AuxBlocks                []*AuxBlock
 }
+
+// True when a refreshed template pays a different coinbase or holds a different transaction set
+func (t *Template) ContentChanged(previous *Template) bool {
+	if previous == nil || t.CoinBaseValue != previous.CoinBaseValue ||
+		len(t.Transactions) != len(previous.Transactions) {
+		return true
+	}
+
+	previousIDs := make(map[string]bool, len(previous.Transactions))
+	for _, transaction := range previous.Transactions {
+		previousIDs[transaction.ID] = true
+	}
+	for _, transaction := range t.Transactions {
+		if !previousIDs[transaction.ID] {
+			return true
+		}
+	}
+
+	return false
+}
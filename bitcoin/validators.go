@@ -1,8 +1,14 @@
 package bitcoin
 
-func (b BitcoinBlock) ValidMainnetAddress(address string) bool {
-	return b.chain.ValidMainnetAddress(address)
+// Checksum and version checked address for the network (main, test or regtest)
+func ValidAddress(chain Blockchain, network, address string) bool {
+	prefixes, err := chain.AddressPrefixes(network)
+	if err != nil {
+		return false
+	}
+	return prefixes.ValidAddress(address)
 }
-func (b BitcoinBlock) ValidTestnetAddress(address string) bool {
-	return b.chain.ValidTestnetAddress(address)
+
+func (b BitcoinBlock) ValidAddress(network, address string) bool {
+	return ValidAddress(b.chain, network, address)
 }
@@ -1,5 +1,7 @@
 package bitcoin
 
+import "math/big"
+
 // Interface to stratum JSON work packets
 type Work []any
 
@@ -15,6 +17,16 @@ func (b BitcoinBlock) Extranonce2SubmissionSlot() (slotID int, exists bool) {
 	return 2, true
 }
 
+// BIP310 version rolling
+func (b BitcoinBlock) VersionBitsSubmissionSlot() (slotID int, exists bool) {
+	return 5, true
+}
+
 func (b BitcoinBlock) ShareMultiplier() float64 {
 	return b.chain.ShareMultiplier()
 }
+
+// Target for a stratum difficulty, which miners measure against the chain's difficulty 1 target
+func (b BitcoinBlock) TargetFromDifficulty(difficulty float64) (Target, big.Accuracy) {
+	return targetFromDifficulty(b.chain.DifficultyOneTarget(), difficulty)
+}
@@ -46,6 +46,37 @@ type apiConfig struct {
 	Port string `json:"port"`
 }
 
+type VarDiffConfig struct {
+	MinDifficulty   float64 `json:"min_difficulty"`
+	MaxDifficulty   float64 `json:"max_difficulty"`
+	TargetTime      string  `json:"target_time"`   // Time between shares we aim for
+	RetargetTime    string  `json:"retarget_time"` // How often a session may be retargeted
+	VariancePercent float64 `json:"variance_percent"`
+}
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+type StratumPortConfig struct {
+	Port           string        `json:"port"`
+	Difficulty     float64       `json:"difficulty"`      // Defaults to pool_difficulty
+	VarDiff        VarDiffConfig `json:"vardiff"`         // Defaults to the pool's vardiff
+	MaxConnections int           `json:"max_connections"` // 0 for no port limit
+	TLS            TLSConfig     `json:"tls"`             // Optional
+}
+
+type BanningConfig struct {
+	BanTime               string  `json:"ban_time"`        // Empty disables banning
+	CheckThreshold        int     `json:"check_threshold"` // Shares to judge before checking invalid_percent
+	InvalidPercent        float64 `json:"invalid_percent"`
+	MalformedRequestLimit int     `json:"malformed_request_limit"` // 0 for no limit
+	ConnectionBurstLimit  int     `json:"connection_burst_limit"`  // Connections per IP per connection_burst_window, 0 for no limit
+	ConnectionBurstWindow string  `json:"connection_burst_window"`
+	SweepInterval         string  `json:"sweep_interval"` // How often expired bans are cleared
+}
+
 type recipient struct {
 	Address    string  `json:"address"`
 	Percentage float64 `json:"percentage"`
@@ -55,6 +86,7 @@ type Chain struct {
 	RewardFrom           string      `json:"reward_from"`
 	MinerMinimumPayment  float32     `json:"miner_min_payment"`
 	PoolRewardRecipients []recipient `json:"pool_rewards"`
+	CoinbaseOutputs      []recipient `json:"coinbase_outputs"` // Paid straight from primary chain blocks
 }
 
 type Chains map[string]Chain // chainName => chain payout config
@@ -70,11 +102,21 @@ type Config struct {
 	BlockSignature     string                   `json:"block_signature"`
 	BlockchainNodes    blockChainNodesConfigMap `json:"blockchains"` // Map order in this config file determines primary vs aux nodes.
 	Port               string                   `json:"port"`
-	MaxConnections     int                      `json:"max_connections"`
+	StratumPorts       []StratumPortConfig      `json:"stratum_ports"`          // Replaces port when set
+	MaxConnections     int                      `json:"max_connections"`        // 0 for no limit
+	MaxIPConnections   int                      `json:"max_connections_per_ip"` // 0 for no limit
 	ConnectionTimeout  string                   `json:"connection_timeout"`
 	PoolDifficulty     float64                  `json:"pool_difficulty"`
+	VarDiff            VarDiffConfig            `json:"vardiff"`
+	VersionRollingMask string                   `json:"version_rolling_mask"` // BIP310, hex
+	Banning            BanningConfig            `json:"banning"`
 	BlockChainOrder    `json:"merged_blockchain_order"`
+	ChainDefinitions   string        `json:"chain_definitions"` // Optional file of extra or overridden chains
 	ShareFlushInterval string        `json:"share_flush_interval"`
+	JobRefreshInterval string        `json:"job_refresh_interval"` // Empty to only send work on new blocks
+	ZMQWatchdogWindow  string        `json:"zmq_watchdog_window"`  // ZMQ silence before polling takes over
+	BlockPollInterval  string        `json:"block_poll_interval"`  // getbestblockhash polling while ZMQ is silent
+	StaleShareGrace    string        `json:"stale_share_grace_period"`
 	HashrateWindow     string        `json:"hashrate_window"`
 	PoolStatsInterval  string        `json:"pool_stats_interval"`
 	Persister          sqlConfig     `json:"persistence"`
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"designs.capital/dogepool/api"
+	"designs.capital/dogepool/bitcoin"
 	"designs.capital/dogepool/config"
 	"designs.capital/dogepool/payouts"
 	"designs.capital/dogepool/persistence"
@@ -22,6 +23,7 @@ func main() {
 		configFileName = "config.json"
 	}
 	configuration := config.LoadConfig(configFileName)
+	loadChains(configuration)
 
 	err := persistence.MakePersister(configuration)
 	if err != nil {
@@ -29,8 +31,8 @@ func main() {
 	}
 
 	rpcManagers := makeRPCManagers(configuration)
-	startPoolServer(configuration, rpcManagers)
-	startStatManager(configuration)
+	poolServer := startPoolServer(configuration, rpcManagers)
+	startStatManager(configuration, poolServer)
 	startAPIServer(configuration)
 	startPayoutService(configuration, rpcManagers)
 	startAppStatsService(configuration)
@@ -41,10 +43,26 @@ func parseCommandLineOptions() string {
 	return flag.Arg(0)
 }
 
+func loadChains(configuration *config.Config) {
+	if configuration.ChainDefinitions != "" {
+		err := bitcoin.LoadChainDefinitions(configuration.ChainDefinitions)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, chainName := range configuration.BlockChainOrder {
+		_, err := bitcoin.GetChain(chainName)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
 func startPoolServer(configuration *config.Config, managers map[string]*rpc.Manager) *pool.PoolServer {
 	poolServer := pool.NewServer(configuration, managers)
 	go poolServer.Start()
-	utils.LogInfo("Started Pool on port: " + configuration.Port)
+	utils.LogInfo("Started Pool")
 	return poolServer
 }
 
@@ -53,10 +71,10 @@ func startAPIServer(configuration *config.Config) {
 	utils.LogInfo("Started API on port: " + configuration.API.Port)
 }
 
-func startStatManager(configuration *config.Config) {
+func startStatManager(configuration *config.Config, poolServer *pool.PoolServer) {
 	hashrateWindow := mustParseDuration(configuration.HashrateWindow)
 	statsRecordInterval := mustParseDuration(configuration.PoolStatsInterval)
-	go persistence.UpdateStatsOnInterval(configuration.PoolName, hashrateWindow, statsRecordInterval)
+	go persistence.UpdateStatsOnInterval(configuration.PoolName, hashrateWindow, statsRecordInterval, poolServer.ConnectionCount)
 	utils.LogInfof("Stat Manager running every %v with a hashrate window of %v\n", statsRecordInterval, hashrateWindow)
 }
 
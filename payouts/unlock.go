@@ -82,7 +82,11 @@ func classifyBlocks(blocks persistence.FoundBlocks, rpcManagers map[string]*rpc.
 
 		switch coinbaseTransaction.Details[0].Category {
 		case "immature":
-			min := bitcoin.GetChain(localBlock.Chain).MinimumConfirmations()
+			chain, err := bitcoin.GetChain(localBlock.Chain)
+			if err != nil {
+				return nil, err
+			}
+			min := chain.MinimumConfirmations()
 			blocks[i].ConfirmationProgress = float32(coinbaseTransaction.Confirmations) / float32(min)
 			blocks[i].ConfirmationProgress = roundToThreeDigits(blocks[i].ConfirmationProgress)
 			blocks[i].Reward = coinbaseTransaction.Amount
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"database/sql"
+	"time"
+)
+
+type BanRepository struct {
+	*sql.DB
+}
+
+type Ban struct {
+	PoolID    string
+	IpAddress string
+	Reason    string
+	Created   time.Time
+	Expires   time.Time
+}
+
+func (r *BanRepository) Insert(ban Ban) error {
+	query := "INSERT INTO bans(poolid, ipaddress, reason, created, expires) "
+	query = query + "VALUES($1, $2, $3, $4, $5) "
+	query = query + "ON CONFLICT ON CONSTRAINT bans_pkey DO UPDATE "
+	query = query + "SET reason = $3, created = $4, expires = $5"
+
+	stmt, err := r.DB.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(ban.PoolID, ban.IpAddress, ban.Reason, ban.Created, ban.Expires)
+	return err
+}
+
+func (r *BanRepository) GetActiveBans(poolID string, now time.Time) ([]Ban, error) {
+	query := "SELECT poolid, ipaddress, reason, created, expires FROM bans WHERE poolid = $1 AND expires > $2"
+
+	stmt, err := r.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(poolID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var ban Ban
+		err = rows.Scan(&ban.PoolID, &ban.IpAddress, &ban.Reason, &ban.Created, &ban.Expires)
+		if err != nil {
+			return bans, err
+		}
+		bans = append(bans, ban)
+	}
+
+	return bans, nil
+}
+
+func (r *BanRepository) DeleteExpiredBans(poolID string, now time.Time) error {
+	query := "DELETE FROM bans WHERE poolid = $1 AND expires <= $2"
+
+	stmt, err := r.DB.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(poolID, now)
+	return err
+}
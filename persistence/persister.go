@@ -10,6 +10,7 @@ import (
 
 var (
 	Balances BalanceRepository
+	Bans     BanRepository
 	Blocks   FoundRepository
 	Miners   MinerRepository
 	Payments PaymentRepository
@@ -30,6 +31,7 @@ func MakePersister(configuration *config.Config) error {
 	}
 
 	Balances = BalanceRepository{db}
+	Bans = BanRepository{db}
 	Blocks = FoundRepository{db}
 	Miners = MinerRepository{db}
 	Payments = PaymentRepository{db}
@@ -12,6 +12,7 @@ type PoolStat struct {
 	PoolID               string
 	ConnectedMiners      uint
 	ConnectedWorkers     uint
+	Connections          uint // Open stratum connections
 	PoolHashrate         float64
 	NetworkHashrate      float64
 	NetworkDifficulty    float64
@@ -28,8 +29,8 @@ type PoolRepository struct {
 
 func (r *PoolRepository) InsertPoolStat(stat PoolStat) error {
 	query := "INSERT INTO poolstats(poolid, connectedminers, connectedworkers, poolhashrate, networkhashrate, networkdifficulty, "
-	query = query + "lastnetworkblocktime, blockheight, connectedpeers, sharespersecond, created) "
-	query = query + "VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"
+	query = query + "lastnetworkblocktime, blockheight, connectedpeers, sharespersecond, connections, created) "
+	query = query + "VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
 
 	stmt, err := r.DB.Prepare(query)
 	if err != nil {
@@ -38,14 +39,14 @@ func (r *PoolRepository) InsertPoolStat(stat PoolStat) error {
 
 	_, err = stmt.Exec(stat.PoolID, stat.ConnectedMiners, stat.ConnectedWorkers, stat.PoolHashrate,
 		stat.NetworkHashrate, stat.NetworkDifficulty, stat.LastNetworkBlockTime, stat.BlockHeight,
-		stat.ConnectedPeers, stat.SharesPerSecond, stat.Created)
+		stat.ConnectedPeers, stat.SharesPerSecond, stat.Connections, stat.Created)
 	return err
 }
 
 func (r *PoolRepository) GetLastStat(poolID string) (PoolStat, error) {
 	stat := PoolStat{}
 	query := "SELECT poolid, connectedminers, connectedworkers, poolhashrate, sharespersecond, networkhashrate, networkdifficulty, "
-	query = query + "lastnetworkblocktime, blockheight, connectedpeers, created "
+	query = query + "lastnetworkblocktime, blockheight, connectedpeers, connections, created "
 	query = query + "FROM poolstats WHERE poolid = $1 ORDER BY created DESC FETCH NEXT 1 ROWS ONLY"
 
 	stmt, err := r.DB.Prepare(query)
@@ -55,7 +56,7 @@ func (r *PoolRepository) GetLastStat(poolID string) (PoolStat, error) {
 
 	err = stmt.QueryRow(poolID).Scan(&stat.PoolID, &stat.ConnectedMiners, &stat.ConnectedWorkers, &stat.PoolHashrate,
 		&stat.SharesPerSecond, &stat.NetworkHashrate, &stat.NetworkDifficulty, &stat.LastNetworkBlockTime,
-		&stat.BlockHeight, &stat.ConnectedPeers, &stat.Created)
+		&stat.BlockHeight, &stat.ConnectedPeers, &stat.Connections, &stat.Created)
 
 	return stat, err
 }
@@ -11,6 +11,7 @@ CREATE TABLE shares
 	useragent TEXT NULL,
 	ipaddress TEXT NOT NULL,
     source TEXT NULL,
+	status TEXT NOT NULL DEFAULT 'valid',
 	created TIMESTAMPTZ NOT NULL
 );
 
@@ -105,6 +106,7 @@ CREATE TABLE poolstats
 	lastnetworkblocktime TIMESTAMPTZ NULL,
     blockheight BIGINT NOT NULL DEFAULT 0,
     connectedpeers INT NOT NULL DEFAULT 0,
+	connections INT NOT NULL DEFAULT 0,
 	created TIMESTAMPTZ NOT NULL
 );
 
@@ -123,4 +125,17 @@ CREATE TABLE minerstats
 
 CREATE INDEX IDX_MINERSTATS_POOL_CREATED on minerstats(poolid, created);
 CREATE INDEX IDX_MINERSTATS_POOL_MINER_CREATED on minerstats(poolid, miner, created);
-CREATE INDEX IDX_MINERSTATS_POOL_MINER_WORKER_CREATED_HASHRATE on minerstats(poolid,miner,worker,created desc,hashrate);
\ No newline at end of file
+CREATE INDEX IDX_MINERSTATS_POOL_MINER_WORKER_CREATED_HASHRATE on minerstats(poolid,miner,worker,created desc,hashrate);
+
+CREATE TABLE bans
+(
+	poolid TEXT NOT NULL,
+	ipaddress TEXT NOT NULL,
+	reason TEXT NULL,
+	created TIMESTAMPTZ NOT NULL,
+	expires TIMESTAMPTZ NOT NULL,
+
+	primary key(poolid, ipaddress)
+);
+
+CREATE INDEX IDX_BANS_POOL_EXPIRES on bans(poolid, expires);
\ No newline at end of file
@@ -14,6 +14,7 @@ CREATE TABLE shares
 	useragent TEXT NULL,
 	ipaddress TEXT NOT NULL,
     source TEXT NULL,
+	status TEXT NOT NULL DEFAULT 'valid',
 	created TIMESTAMP WITH TIME ZONE NOT NULL
 ) PARTITION BY LIST (poolid);
 
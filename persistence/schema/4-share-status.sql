@@ -0,0 +1,4 @@
+SET ROLE mergedmining;
+
+/* Upgrades existing databases, new ones already have this column */
+ALTER TABLE shares ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'valid';
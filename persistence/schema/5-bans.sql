@@ -0,0 +1,14 @@
+SET ROLE mergedmining;
+
+CREATE TABLE IF NOT EXISTS bans
+(
+	poolid TEXT NOT NULL,
+	ipaddress TEXT NOT NULL,
+	reason TEXT NULL,
+	created TIMESTAMPTZ NOT NULL,
+	expires TIMESTAMPTZ NOT NULL,
+
+	primary key(poolid, ipaddress)
+);
+
+CREATE INDEX IF NOT EXISTS IDX_BANS_POOL_EXPIRES on bans(poolid, expires);
@@ -0,0 +1,4 @@
+SET ROLE mergedmining;
+
+/* Upgrades existing databases, new ones already have this column */
+ALTER TABLE poolstats ADD COLUMN IF NOT EXISTS connections INT NOT NULL DEFAULT 0;
@@ -8,6 +8,7 @@ DROP TABLE balance_changes;
 DROP TABLE miner_settings;
 DROP TABLE poolstats;
 DROP TABLE minerstats;
+DROP TABLE IF EXISTS bans;
 
 CREATE TABLE shares
 (
@@ -20,6 +21,7 @@ CREATE TABLE shares
 	useragent TEXT NULL,
 	ipaddress TEXT NOT NULL,
     source TEXT NULL,
+	status TEXT NOT NULL DEFAULT 'valid',
 	created TIMESTAMPTZ NOT NULL
 );
 
@@ -103,6 +105,7 @@ CREATE TABLE poolstats
 	lastnetworkblocktime TIMESTAMPTZ NULL,
     blockheight BIGINT NOT NULL DEFAULT 0,
     connectedpeers INT NOT NULL DEFAULT 0,
+	connections INT NOT NULL DEFAULT 0,
 	created TIMESTAMPTZ NOT NULL
 );
 
@@ -116,3 +119,16 @@ CREATE TABLE minerstats
 	sharespersecond DOUBLE PRECISION NOT NULL DEFAULT 0,
 	created TIMESTAMPTZ NOT NULL
 );
+
+CREATE TABLE bans
+(
+	poolid TEXT NOT NULL,
+	ipaddress TEXT NOT NULL,
+	reason TEXT NULL,
+	created TIMESTAMPTZ NOT NULL,
+	expires TIMESTAMPTZ NOT NULL,
+
+	primary key(poolid, ipaddress)
+);
+
+CREATE INDEX IDX_BANS_POOL_EXPIRES on bans(poolid, expires);
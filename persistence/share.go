@@ -8,6 +8,12 @@ import (
 	"github.com/lib/pq"
 )
 
+const (
+	ShareStatusValid     = "valid"
+	ShareStatusStale     = "stale"     // Mined on a previous block, credited within the grace period and recorded with 0 difficulty after it
+	ShareStatusDuplicate = "duplicate" // Resubmitted on the same job, recorded with 0 difficulty against the worker
+)
+
 type Share struct {
 	PoolID            string
 	BlockHeight       uint
@@ -17,6 +23,7 @@ type Share struct {
 	Difficulty        float64
 	NetworkDifficulty float64
 	IpAddress         string
+	Status            string
 	Created           time.Time
 }
 
@@ -31,7 +38,7 @@ func (r *ShareRepository) InsertBatch(shares []Share) error {
 	}
 
 	fields := pq.CopyIn("shares", "poolid", "blockheight", "difficulty", "networkdifficulty",
-		"miner", "worker", "useragent", "ipaddress", "source", "created")
+		"miner", "worker", "useragent", "ipaddress", "source", "status", "created")
 	stmt, err := txn.Prepare(fields)
 	if err != nil {
 		return err
@@ -40,7 +47,7 @@ func (r *ShareRepository) InsertBatch(shares []Share) error {
 	for _, share := range shares {
 		_, err = stmt.Exec(share.PoolID, share.BlockHeight, share.Difficulty,
 			share.NetworkDifficulty, share.Miner, share.Worker, share.UserAgent, share.IpAddress,
-			"", share.Created)
+			"", share.Status, share.Created)
 		if err != nil {
 			return err
 		}
@@ -249,7 +256,7 @@ func (results *MinerWorkerHashAccumulationResultSet) GroupByMiner() map[string][
 
 func (r *ShareRepository) GetWorkerHashAccumulationBetween(poolID string, start, end time.Time) (MinerWorkerHashAccumulationResultSet, error) {
 
-	query := "SELECT SUM(difficulty), COUNT(difficulty), MIN(created) AS firstshare, MAX(created) AS lastshare, miner, worker "
+	query := "SELECT SUM(difficulty), COUNT(difficulty) FILTER (WHERE difficulty > 0), MIN(created) AS firstshare, MAX(created) AS lastshare, miner, worker "
 	query = query + "FROM shares WHERE poolid = $1 AND created >= $2 AND created <= $3 GROUP BY miner, worker"
 
 	stmt, err := r.DB.Prepare(query)
@@ -280,6 +287,46 @@ func (r *ShareRepository) GetWorkerHashAccumulationBetween(poolID string, start,
 	return workers, nil
 }
 
+type WorkerShareRateMap map[string]float64 // Worker => Shares with the status / all shares
+
+func (r *ShareRepository) GetWorkerStaleRatesBetween(poolID, miner string, start, end time.Time) (WorkerShareRateMap, error) {
+	return r.getWorkerStatusRatesBetween(ShareStatusStale, poolID, miner, start, end)
+}
+
+func (r *ShareRepository) GetWorkerDuplicateRatesBetween(poolID, miner string, start, end time.Time) (WorkerShareRateMap, error) {
+	return r.getWorkerStatusRatesBetween(ShareStatusDuplicate, poolID, miner, start, end)
+}
+
+func (r *ShareRepository) getWorkerStatusRatesBetween(status, poolID, miner string, start, end time.Time) (WorkerShareRateMap, error) {
+	rates := make(WorkerShareRateMap)
+
+	query := "SELECT worker, COUNT(*) FILTER (WHERE status = $1)::DOUBLE PRECISION / COUNT(*) "
+	query = query + "FROM shares WHERE poolid = $2 AND miner = $3 AND created >= $4 AND created <= $5 GROUP BY worker"
+
+	stmt, err := r.DB.Prepare(query)
+	if err != nil {
+		return rates, err
+	}
+
+	rows, err := stmt.Query(status, poolID, miner, start, end)
+	if err != nil {
+		return rates, err
+	}
+
+	for rows.Next() {
+		var worker string
+		var rate float64
+		err = rows.Scan(&worker, &rate)
+		if err != nil {
+			return rates, err
+		}
+
+		rates[worker] = rate
+	}
+
+	return rates, nil
+}
+
 type UserAgentShareDifficultyMap map[string]float64 // UserAgent => Difficulty
 
 func (r *ShareRepository) GetAccumulatedUserAgentShareDifficultyBetween(poolID string, start, end time.Time, byVersion bool) (UserAgentShareDifficultyMap, error) {
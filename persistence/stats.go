@@ -6,12 +6,12 @@ import (
 	"time"
 )
 
-func UpdateStatsOnInterval(poolID string, hashRateCalculationWindow, interval time.Duration) {
+func UpdateStatsOnInterval(poolID string, hashRateCalculationWindow, interval time.Duration, connectionCount func() uint) {
 	var err error
 	for {
 		time.Sleep(interval)
 
-		err = insertManyNewMinerStatsAndOnePoolStat(poolID, hashRateCalculationWindow)
+		err = insertManyNewMinerStatsAndOnePoolStat(poolID, hashRateCalculationWindow, connectionCount())
 		if err != nil {
 			log.Println(err)
 		} else {
@@ -20,7 +20,7 @@ func UpdateStatsOnInterval(poolID string, hashRateCalculationWindow, interval ti
 	}
 }
 
-func insertManyNewMinerStatsAndOnePoolStat(poolID string, hashRateCalculationWindow time.Duration) error {
+func insertManyNewMinerStatsAndOnePoolStat(poolID string, hashRateCalculationWindow time.Duration, connections uint) error {
 	now := time.Now()
 	timeFrom := time.Now().Add(-hashRateCalculationWindow)
 
@@ -30,7 +30,7 @@ func insertManyNewMinerStatsAndOnePoolStat(poolID string, hashRateCalculationWin
 	}
 	miners := workers.GroupByMiner()
 
-	err = makeNewPoolStat(poolID, hashRateCalculationWindow, workers, uint(len(miners)), now)
+	err = makeNewPoolStat(poolID, hashRateCalculationWindow, workers, uint(len(miners)), connections, now)
 	if err != nil {
 		log.Println(err)
 	}
@@ -40,10 +40,11 @@ func insertManyNewMinerStatsAndOnePoolStat(poolID string, hashRateCalculationWin
 	return nil
 }
 
-func makeNewPoolStat(poolID string, hashRateCalculationWindow time.Duration, workers MinerWorkerHashAccumulationResultSet, minerCount uint, now time.Time) error {
+func makeNewPoolStat(poolID string, hashRateCalculationWindow time.Duration, workers MinerWorkerHashAccumulationResultSet, minerCount, connections uint, now time.Time) error {
 	poolStat := PoolStat{
-		PoolID:  poolID,
-		Created: now,
+		PoolID:      poolID,
+		Connections: connections,
+		Created:     now,
 	}
 
 	if workers != nil {
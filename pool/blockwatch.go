@@ -0,0 +1,252 @@
+package pool
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"designs.capital/dogepool/rpc"
+	"designs.capital/dogepool/utils"
+	"github.com/go-zeromq/zmq4"
+)
+
+// Learns about new blocks through ZMQ, and polls getbestblockhash whenever ZMQ goes silent.
+// Chains without a block_notify_url use getblocktemplate long polling instead.
+
+const (
+	defaultZMQWatchdogWindow = 5 * time.Minute
+	defaultBlockPollInterval = 2 * time.Second
+	minReconnectBackoff      = time.Second
+	maxReconnectBackoff      = time.Minute
+)
+
+type blockWatcher struct {
+	sync.Mutex
+	pool           *PoolServer
+	chainName      string
+	notifyURL      string
+	watchdogWindow time.Duration
+	pollInterval   time.Duration
+	notifyChannel  chan hashBlockResponse
+
+	lastMessage time.Time // Last ZMQ message
+	lastHash    string    // Last block reported, from either source
+	polling     bool
+}
+
+func (pool *PoolServer) newBlockWatcher(chainName string, notifyChannel chan hashBlockResponse) *blockWatcher {
+	watcher := &blockWatcher{
+		pool:           pool,
+		chainName:      chainName,
+		notifyURL:      pool.activeNodes[chainName].NotifyURL,
+		watchdogWindow: defaultZMQWatchdogWindow,
+		pollInterval:   defaultBlockPollInterval,
+		notifyChannel:  notifyChannel,
+		lastMessage:    time.Now(),
+	}
+	if pool.config.ZMQWatchdogWindow != "" {
+		watcher.watchdogWindow = mustParseDuration(pool.config.ZMQWatchdogWindow)
+	}
+	if pool.config.BlockPollInterval != "" {
+		watcher.pollInterval = mustParseDuration(pool.config.BlockPollInterval)
+	}
+
+	// So the first poll doesn't report the block we already have work for
+	hash, err := watcher.bestBlockHash()
+	logOnError(err)
+	watcher.lastHash = hash
+
+	return watcher
+}
+
+func (w *blockWatcher) start() {
+	if w.notifyURL == "" {
+		utils.LogInfof("%v has no block_notify_url, long polling getblocktemplate", w.chainName)
+		go w.longPoll()
+		return
+	}
+
+	go w.subscribeWithBackoff()
+	go w.watchdog()
+}
+
+func (w *blockWatcher) subscribeWithBackoff() {
+	backoff := minReconnectBackoff
+	for {
+		received, err := w.subscribe()
+		if received {
+			backoff = minReconnectBackoff
+		}
+		m := "%v ZMQ subscription to %v failed, reconnecting in %v: %v"
+		utils.LogErrorf(m, w.chainName, w.notifyURL, backoff, err)
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// Returns once the socket fails, and whether it delivered anything before that
+func (w *blockWatcher) subscribe() (bool, error) {
+	sub := zmq4.NewSub(context.Background())
+	defer sub.Close()
+
+	err := sub.Dial(w.notifyURL)
+	if err != nil {
+		return false, err
+	}
+
+	err = sub.SetOption(zmq4.OptionSubscribe, "hashblock")
+	if err != nil {
+		return false, err
+	}
+
+	received := false
+	for {
+		msg, err := sub.Recv()
+		if err != nil {
+			return received, err
+		}
+		if len(msg.Frames) < 3 || len(msg.Frames[2]) < 4 {
+			continue
+		}
+		received = true
+
+		var blockHashCounter uint32
+		blockHashCounter |= uint32(msg.Frames[2][0])
+		blockHashCounter |= uint32(msg.Frames[2][1]) << 8
+		blockHashCounter |= uint32(msg.Frames[2][2]) << 16
+		blockHashCounter |= uint32(msg.Frames[2][3]) << 24
+
+		hash := hex.EncodeToString(msg.Frames[1])
+		if !w.zmqMessage(hash) {
+			continue // Polling already reported it
+		}
+
+		w.notifyChannel <- hashBlockResponse{
+			blockChainName:    w.chainName,
+			previousBlockHash: hash,
+			blockHashCounter:  blockHashCounter,
+		}
+	}
+}
+
+// Returns false when the block was already reported
+func (w *blockWatcher) zmqMessage(hash string) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	w.lastMessage = time.Now()
+	if w.polling {
+		w.polling = false
+		utils.LogInfof("%v ZMQ notifications are back, stopped polling", w.chainName)
+	}
+
+	if hash == w.lastHash {
+		return false
+	}
+	w.lastHash = hash
+	return true
+}
+
+func (w *blockWatcher) watchdog() {
+	for {
+		time.Sleep(w.pollInterval)
+
+		w.Lock()
+		silence := time.Since(w.lastMessage)
+		if !w.polling && silence > w.watchdogWindow {
+			w.polling = true
+			m := "No %v ZMQ notification for %v, polling getbestblockhash every %v"
+			utils.LogInfof(m, w.chainName, silence.Round(time.Second), w.pollInterval)
+		}
+		polling := w.polling
+		w.Unlock()
+
+		if !polling {
+			continue
+		}
+
+		hash, err := w.bestBlockHash()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		w.Lock()
+		newBlock := hash != w.lastHash
+		w.lastHash = hash
+		w.Unlock()
+
+		if newBlock {
+			// Polled blocks have no ZMQ sequence number
+			w.notifyChannel <- hashBlockResponse{
+				blockChainName:    w.chainName,
+				previousBlockHash: hash,
+			}
+		}
+	}
+}
+
+func (w *blockWatcher) longPoll() {
+	longPollID := ""
+	backoff := minReconnectBackoff
+	for {
+		client, err := w.rpcClient()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		reply, err := client.GetBlockTemplateLongPoll(longPollID)
+		if err != nil {
+			m := "%v long poll failed, retrying in %v: %v"
+			utils.LogErrorf(m, w.chainName, backoff, err)
+
+			longPollID = "" // The node may have restarted or failed over
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+		backoff = minReconnectBackoff
+		longPollID = reply.LongPollID
+
+		// Templates also change with new transactions, only a new tip is a new block
+		w.Lock()
+		newBlock := reply.PreviousBlockHash != w.lastHash
+		w.lastHash = reply.PreviousBlockHash
+		w.Unlock()
+
+		if newBlock {
+			// Long polled blocks have no ZMQ sequence number
+			w.notifyChannel <- hashBlockResponse{
+				blockChainName:    w.chainName,
+				previousBlockHash: reply.PreviousBlockHash,
+			}
+		}
+	}
+}
+
+func (w *blockWatcher) rpcClient() (*rpc.RPCClient, error) {
+	manager, exists := w.pool.rpcManagers[w.chainName]
+	if !exists {
+		return nil, errors.New("no RPC manager for " + w.chainName)
+	}
+	return manager.GetActiveClient(), nil
+}
+
+func (w *blockWatcher) bestBlockHash() (string, error) {
+	client, err := w.rpcClient()
+	if err != nil {
+		return "", err
+	}
+	return client.GetBestBlockHash()
+}
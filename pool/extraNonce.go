@@ -2,12 +2,22 @@ package pool
 
 import (
 	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+
+	"designs.capital/dogepool/bitcoin"
+	"designs.capital/dogepool/utils"
 )
 
 var extranonces map[string]bool
+var extranonceLock sync.Mutex
 
 func uniqueExtranonce(length int) string {
+	extranonceLock.Lock()
+	defer extranonceLock.Unlock()
 
 	if extranonces == nil {
 		extranonces = make(map[string]bool)
@@ -26,6 +36,12 @@ func uniqueExtranonce(length int) string {
 	return extranonce
 }
 
+func releaseExtranonce(extranonce string) {
+	extranonceLock.Lock()
+	defer extranonceLock.Unlock()
+	delete(extranonces, extranonce)
+}
+
 func randomHex(strlen int) string {
 	rand.Seed(time.Now().UTC().UnixNano())
 	const chars = "0123456789abcdef"
@@ -35,3 +51,65 @@ func randomHex(strlen int) string {
 	}
 	return string(result)
 }
+
+// Gives a session a new extranonce1 along with work it hasn't been sent yet, so shares
+// in flight for older jobs are still judged with the old extranonce1.
+// Sessions that can't be told about it are closed.
+func (pool *PoolServer) reassignExtranonce(client *stratumClient, work bitcoin.Work) error {
+	client.Lock()
+	select {
+	case <-client.closed:
+		client.Unlock()
+		return nil // Closing released its extranonce already
+	default:
+	}
+	// The old extranonce stays reserved until the new one is taken
+	oldExtranonce := client.extranonce1
+	client.extranonce1 = uniqueExtranonce(extranonce1Length * 2)
+	newExtranonce := client.extranonce1
+	subscribed := client.extranonceSubscribed
+	client.Unlock()
+
+	releaseExtranonce(oldExtranonce)
+
+	if !subscribed {
+		return client.connection.Close()
+	}
+
+	err := sendPacket(miningSetExtranonce(newExtranonce, extranonce2Length), client)
+	if err != nil {
+		return err
+	}
+
+	request, err := client.miningNotify(work)
+	if err != nil {
+		return err
+	}
+
+	return sendPacket(request, client)
+}
+
+// Hands every session a new extranonce1, I.e. to recover from leaked allocations
+func (pool *PoolServer) restartExtranonceAllocator() {
+	work, err := pool.reissueWork()
+	if err != nil {
+		logOnError(err)
+		return
+	}
+
+	sessions := pool.sessions.all()
+	for _, client := range sessions {
+		err = pool.reassignExtranonce(client, work)
+		logOnError(err)
+	}
+	utils.LogInfof("Reassigned extranonce1 for %v session(s)", len(sessions))
+}
+
+func (pool *PoolServer) restartExtranonceAllocatorOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	for range signals {
+		pool.restartExtranonceAllocator()
+	}
+}
@@ -0,0 +1,512 @@
+package pool
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"designs.capital/dogepool/bitcoin"
+	"designs.capital/dogepool/config"
+	"designs.capital/dogepool/persistence"
+	"designs.capital/dogepool/rpc"
+)
+
+// Regtest style work: a bitcoin parent with a namecoin aux block, both at the regtest limit
+const (
+	regtestTarget    = "7fffff0000000000000000000000000000000000000000000000000000000000"
+	regtestAuxTarget = "0000000000000000000000000000000000000000000000000000000000ffff7f" // createauxblock's _target, little endian
+	impossibleTarget = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	namecoinAuxHash   = "2d4c5e8f0a1b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5"
+	testRewardScript  = "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac"
+	testExtranonce1   = "0a0b0c0d"
+	testExtranonce2   = "00000001"
+	testBlockTime     = 0x6530c000
+	testBlockTimeHex  = "6530c000"
+	testBlockVersion  = 0x20000000
+	bitcoinDiff1Bytes = "00000000ffff0000000000000000000000000000000000000000000000000000"
+)
+
+func regtestTemplate(target, auxTarget string) bitcoin.Template {
+	return bitcoin.Template{
+		Version:       testBlockVersion,
+		PrevBlockHash: "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+		Height:        150,
+		CoinBaseValue: 5000000000,
+		Bits:          "207fffff",
+		Target:        bitcoin.Target(target),
+		CurrentTime:   testBlockTime,
+		AuxBlocks: []*bitcoin.AuxBlock{{
+			Hash:    namecoinAuxHash,
+			ChainID: 1,
+			Height:  210,
+			Target:  auxTarget,
+		}},
+	}
+}
+
+// Like cacheWork, then a regtest miner grinding nonces up to the regtest target
+func mineRegtestShare(t *testing.T, template bitcoin.Template) (*bitcoin.BitcoinBlock, string, string) {
+	t.Helper()
+
+	scriptSig, err := createMergedMiningCoinbase(template.AuxBlocks, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := "/dogepool/" + hexStringToByteString(scriptSig)
+
+	block, work, err := bitcoin.GenerateWork(&template, "bitcoin", signature, testRewardScript, nil, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := work[2].(string) + testExtranonce1 + testExtranonce2 + work[3].(string)
+
+	nonce := grindRegtestNonce(t, *block, testExtranonce1+testExtranonce2)
+	header, err := block.MakeHeader(testExtranonce1+testExtranonce2, nonce, testBlockTimeHex, template.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := block.Sum(); err != nil { // Sets the header hash auxpows carry
+		t.Fatal(err)
+	}
+
+	return block, header, coinbase
+}
+
+func grindRegtestNonce(t *testing.T, block bitcoin.BitcoinBlock, extranonce string) string {
+	t.Helper()
+
+	target := bitcoin.Target(regtestTarget)
+	targetBig, _ := target.ToBig()
+	for nonce := uint32(0); nonce < 1000; nonce++ {
+		_, err := block.MakeHeader(extranonce, fmt.Sprintf("%08x", nonce), testBlockTimeHex, block.Template.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, err := block.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum.Cmp(targetBig) <= 0 {
+			return fmt.Sprintf("%08x", nonce)
+		}
+	}
+
+	t.Fatal("no nonce meets the regtest target")
+	return ""
+}
+
+// Namecoin's slot for a chain ID and merkle nonce (CAuxPow::getExpectedIndex)
+func expectedAuxSlot(chainID, nonce uint32, height uint) uint32 {
+	random := nonce
+	random = random*1103515245 + 12345
+	random += chainID
+	random = random*1103515245 + 12345
+	return random % (1 << height)
+}
+
+func doubleSha256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+func reversed(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[len(data)-1-i] = data[i]
+	}
+	return out
+}
+
+func TestMergedMiningCommitmentUsesAuxSlot(t *testing.T) {
+	_, _, coinbase := mineRegtestShare(t, regtestTemplate(regtestTarget, regtestAuxTarget))
+
+	// 16 leaves, zero except for namecoin's slot, which holds its block hash in internal byte order
+	auxHash, _ := hex.DecodeString(namecoinAuxHash)
+	level := make([][]byte, 16)
+	for i := range level {
+		level[i] = make([]byte, 32)
+	}
+	level[expectedAuxSlot(1, 0, 4)] = reversed(auxHash)
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, doubleSha256(append(append([]byte{}, level[i]...), level[i+1]...)))
+		}
+		level = next
+	}
+
+	// The root as displayed, followed by the tree size and nonce
+	commitment := "fabe6d6d" + hex.EncodeToString(reversed(level[0])) + "10000000" + "00000000"
+	if !strings.Contains(coinbase, commitment) {
+		t.Fatalf("coinbase %v doesn't commit to %v", coinbase, commitment)
+	}
+}
+
+func TestSha256dParentShare(t *testing.T) {
+	block, header, _ := mineRegtestShare(t, regtestTemplate(regtestTarget, regtestAuxTarget))
+
+	headerBytes, err := hex.DecodeString(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headerBytes) != 80 {
+		t.Fatalf("header is %v bytes", len(headerBytes))
+	}
+	want := new(big.Int).SetBytes(reversed(doubleSha256(headerBytes)))
+
+	sum, err := block.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Cmp(want) != 0 {
+		t.Fatalf("header digest: got %x, want sha256d %x", sum, want)
+	}
+
+	diff1Target, _ := block.TargetFromDifficulty(1)
+	diff1TargetBig, _ := diff1Target.ToBig()
+	bitcoinDiff1 := bitcoin.Target(bitcoinDiff1Bytes)
+	bitcoinDiff1Big, _ := bitcoinDiff1.ToBig()
+	if diff1TargetBig.Cmp(bitcoinDiff1Big) != 0 {
+		t.Fatalf("difficulty 1 target: got %v, want %v", diff1Target, bitcoinDiff1Bytes)
+	}
+
+	if block.ShareMultiplier() != 1 {
+		t.Fatalf("share multiplier: got %v, want 1", block.ShareMultiplier())
+	}
+	regtestDifficulty := bitcoin.Target(regtestTarget)
+	wantNetworkDifficulty, _ := regtestDifficulty.ToDifficulty()
+	if networkDifficulty(block) != wantNetworkDifficulty {
+		t.Fatalf("network difficulty: got %v, want %v", networkDifficulty(block), wantNetworkDifficulty)
+	}
+}
+
+func TestSha256dParentShareWeights(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		auxTarget      string
+		poolDifficulty float64
+		status         int
+		candidate      []bool
+	}{
+		{"both chains", regtestTarget, regtestAuxTarget, 1, shareCandidate, []bool{true, true}},
+		{"aux only", impossibleTarget, regtestAuxTarget, 1, shareCandidate, []bool{false, true}},
+		{"parent only", regtestTarget, impossibleTarget, 1, shareCandidate, []bool{true, false}},
+		{"pool share", impossibleTarget, impossibleTarget, 1e-12, shareValid, []bool{false, false}},
+		{"low difficulty", impossibleTarget, impossibleTarget, 1e30, shareInvalid, []bool{false, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block, _, _ := mineRegtestShare(t, regtestTemplate(test.target, test.auxTarget))
+
+			status, candidate, shareDifficulty := validateAndWeighShare(block, test.poolDifficulty)
+			if status != test.status {
+				t.Fatalf("status: got %v, want %v", status, test.status)
+			}
+			if len(candidate) != len(test.candidate) {
+				t.Fatalf("candidates: got %v, want %v", candidate, test.candidate)
+			}
+			for i := range candidate {
+				if candidate[i] != test.candidate[i] {
+					t.Fatalf("candidates: got %v, want %v", candidate, test.candidate)
+				}
+			}
+			// No scrypt scaling, stratum difficulty is the share's difficulty
+			if shareDifficulty != test.poolDifficulty {
+				t.Fatalf("share difficulty: got %v, want %v", shareDifficulty, test.poolDifficulty)
+			}
+		})
+	}
+}
+
+// Daemons that accept every block, recording what they were sent
+type rpcStub struct {
+	sync.Mutex
+	server *httptest.Server
+	calls  map[string][][]any // Method => params of each call
+}
+
+func newRPCStub(t *testing.T, results map[string]string) *rpcStub {
+	stub := &rpcStub{calls: make(map[string][][]any)}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stub.Lock()
+		stub.calls[request.Method] = append(stub.calls[request.Method], request.Params)
+		stub.Unlock()
+
+		result, exists := results[request.Method]
+		if !exists {
+			http.Error(w, "unexpected "+request.Method, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"result":%v,"error":null,"id":1219}`, result)
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (stub *rpcStub) client() *rpc.RPCClient {
+	return rpc.NewRPCClient("stub", stub.server.URL, "user", "password", "5s")
+}
+
+func (stub *rpcStub) called(method string) [][]any {
+	stub.Lock()
+	defer stub.Unlock()
+	return stub.calls[method]
+}
+
+// Records the chains of found blocks instead of writing them to postgres.
+// sql.Register takes a driver once per process, so tests swap the recorder behind it.
+type foundRecorder struct {
+	sync.Mutex
+	chains []string
+}
+
+var (
+	registerFoundDriver sync.Once
+	foundRecorders      atomic.Value // *foundRecorder
+)
+
+type foundDriver struct{}
+
+func (foundDriver) Open(string) (driver.Conn, error) { return foundConn{}, nil }
+
+type foundConn struct{}
+
+func (foundConn) Prepare(string) (driver.Stmt, error) { return foundStmt{}, nil }
+func (foundConn) Close() error                        { return nil }
+func (foundConn) Begin() (driver.Tx, error)           { return nil, errors.New("no transactions") }
+
+type foundStmt struct{}
+
+func (foundStmt) Close() error  { return nil }
+func (foundStmt) NumInput() int { return -1 }
+func (foundStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("no queries")
+}
+func (foundStmt) Exec(args []driver.Value) (driver.Result, error) {
+	recorder := foundRecorders.Load().(*foundRecorder)
+	recorder.Lock()
+	defer recorder.Unlock()
+	recorder.chains = append(recorder.chains, args[1].(string))
+	return driver.RowsAffected(1), nil
+}
+
+func recordFoundBlocks(t *testing.T) *foundRecorder {
+	registerFoundDriver.Do(func() {
+		sql.Register("found", foundDriver{})
+	})
+	recorder := &foundRecorder{}
+	foundRecorders.Store(recorder)
+
+	db, err := sql.Open("found", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := persistence.Blocks
+	persistence.Blocks = persistence.FoundRepository{DB: db}
+	t.Cleanup(func() {
+		persistence.Blocks = previous
+		db.Close()
+	})
+	return recorder
+}
+
+func (recorder *foundRecorder) found() []string {
+	recorder.Lock()
+	defer recorder.Unlock()
+	return append([]string{}, recorder.chains...)
+}
+
+// Checks the auxpow like namecoin's CAuxPow::check: the coinbase is in the parent block,
+// and commits to the aux block hash through the chain merkle branch at the chain's slot.
+func checkAuxPow(t *testing.T, auxpow, coinbase, auxHash string, chainID uint32) {
+	t.Helper()
+
+	if !strings.HasPrefix(auxpow, coinbase) {
+		t.Fatalf("auxpow %v doesn't start with the coinbase %v", auxpow, coinbase)
+	}
+	payload, err := hex.DecodeString(auxpow[len(coinbase):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(n int) []byte {
+		if len(payload) < n {
+			t.Fatalf("auxpow ends early: %v", auxpow)
+		}
+		read := payload[:n]
+		payload = payload[n:]
+		return read
+	}
+
+	next(32) // Parent block hash, unchecked
+	if parentBranch := next(1)[0]; parentBranch != 0 {
+		t.Fatalf("parent merkle branch of %v, the coinbase is the only transaction", parentBranch)
+	}
+	if index := binary.LittleEndian.Uint32(next(4)); index != 0 {
+		t.Fatalf("coinbase index %v", index)
+	}
+
+	branchLength := int(next(1)[0])
+	branch := make([][]byte, branchLength)
+	for i := range branch {
+		branch[i] = next(32)
+	}
+	chainIndex := binary.LittleEndian.Uint32(next(4))
+
+	header := next(80)
+	if len(payload) != 0 {
+		t.Fatalf("%v bytes after the parent header", len(payload))
+	}
+	coinbaseBytes, _ := hex.DecodeString(coinbase)
+	if !bytes.Equal(header[36:68], doubleSha256(coinbaseBytes)) {
+		t.Fatal("parent header doesn't commit to the coinbase")
+	}
+
+	if want := expectedAuxSlot(chainID, 0, uint(branchLength)); chainIndex != want {
+		t.Fatalf("chain index %v, want %v for a tree of height %v", chainIndex, want, branchLength)
+	}
+
+	hash, _ := hex.DecodeString(auxHash)
+	root := reversed(hash)
+	for i, sibling := range branch {
+		if chainIndex>>i&1 == 1 {
+			root = doubleSha256(append(append([]byte{}, sibling...), root...))
+		} else {
+			root = doubleSha256(append(append([]byte{}, root...), sibling...))
+		}
+	}
+
+	commitment := "fabe6d6d" + hex.EncodeToString(reversed(root)) + fmt.Sprintf("%08x", bits.ReverseBytes32(1<<branchLength)) + "00000000"
+	if !strings.Contains(coinbase, commitment) {
+		t.Fatalf("coinbase %v doesn't commit to %v", coinbase, commitment)
+	}
+}
+
+func TestMergedMinedBlockSubmission(t *testing.T) {
+	bitcoind := newRPCStub(t, map[string]string{"submitblock": "null"})
+	namecoind := newRPCStub(t, map[string]string{"submitauxblock": "true"})
+	found := recordFoundBlocks(t)
+
+	pool := &PoolServer{
+		config: &config.Config{
+			PoolName:        "regtest",
+			BlockSignature:  "/dogepool/",
+			BlockChainOrder: config.BlockChainOrder{"bitcoin", "namecoin"},
+		},
+		activeNodes: BlockChainNodesMap{
+			"bitcoin":  {ChainName: "bitcoin", Network: "regtest", RPC: bitcoind.client(), RewardPubScriptKey: testRewardScript},
+			"namecoin": {ChainName: "namecoin", Network: "regtest", RPC: namecoind.client()},
+		},
+	}
+	template := regtestTemplate(regtestTarget, regtestAuxTarget)
+	pool.workLock.Lock()
+	err := pool.cacheWork(template, template.AuxBlocks)
+	pool.workLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080.rig1"
+	client := &stratumClient{
+		ip:                "192.0.2.1",
+		extranonce1:       testExtranonce1,
+		pendingDifficulty: 1e-6,
+		outbound:          make(chan []byte, outboundQueueSize),
+	}
+	client.authorizeWorker(worker)
+	work, err := pool.generateWorkFromCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.miningNotify(work); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID := work[0].(string)
+	shareJob, _ := pool.jobs.get(jobID)
+	nonce := grindRegtestNonce(t, shareJob.block, testExtranonce1+testExtranonce2)
+
+	share := bitcoin.Work{worker, jobID, testExtranonce2, testBlockTimeHex, nonce}
+	if err := pool.recieveWorkFromClient(share, client); err != nil {
+		t.Fatal(err)
+	}
+
+	// The block as miners built it: the coinbase from the job's halves, and a header on the template
+	coinbase := work[2].(string) + testExtranonce1 + testExtranonce2 + work[3].(string)
+
+	submitted := bitcoind.called("submitblock")
+	if len(submitted) != 1 || len(submitted[0]) != 1 {
+		t.Fatalf("submitblock calls: %v", submitted)
+	}
+	submission := submitted[0][0].(string)
+	if len(submission) < 160 || submission[160:] != "01"+coinbase {
+		t.Fatalf("submitted block %v, want a header, 1 transaction and the coinbase %v", submission, coinbase)
+	}
+	header, _ := hex.DecodeString(submission[:160])
+	prevBlockHash, _ := hex.DecodeString(template.PrevBlockHash)
+	coinbaseBytes, _ := hex.DecodeString(coinbase)
+	nonceBytes, _ := hex.DecodeString(nonce)
+	checks := []struct {
+		field     string
+		got, want []byte
+	}{
+		{"version", header[0:4], binary.LittleEndian.AppendUint32(nil, testBlockVersion)},
+		{"previous block", header[4:36], reversed(prevBlockHash)},
+		{"merkle root", header[36:68], doubleSha256(coinbaseBytes)},
+		{"time", header[68:72], binary.LittleEndian.AppendUint32(nil, testBlockTime)},
+		{"bits", header[72:76], []byte{0xff, 0xff, 0x7f, 0x20}},
+		{"nonce", header[76:80], reversed(nonceBytes)},
+	}
+	for _, check := range checks {
+		if !bytes.Equal(check.got, check.want) {
+			t.Fatalf("header %v: got %x, want %x", check.field, check.got, check.want)
+		}
+	}
+	regtest := bitcoin.Target(regtestTarget)
+	regtestBig, _ := regtest.ToBig()
+	if new(big.Int).SetBytes(reversed(doubleSha256(header))).Cmp(regtestBig) > 0 {
+		t.Fatal("submitted header doesn't meet the regtest target")
+	}
+
+	auxSubmitted := namecoind.called("submitauxblock")
+	if len(auxSubmitted) != 1 || len(auxSubmitted[0]) != 2 {
+		t.Fatalf("submitauxblock calls: %v", auxSubmitted)
+	}
+	if auxSubmitted[0][0] != namecoinAuxHash {
+		t.Fatalf("submitted aux block %v, want %v", auxSubmitted[0][0], namecoinAuxHash)
+	}
+	auxpow := auxSubmitted[0][1].(string)
+	checkAuxPow(t, auxpow, coinbase, namecoinAuxHash, 1)
+	if !strings.HasSuffix(auxpow, submission[:160]) {
+		t.Fatal("auxpow parent header isn't the submitted block's header")
+	}
+
+	if chains := found.found(); len(chains) != 2 || chains[0] != "bitcoin" || chains[1] != "namecoin" {
+		t.Fatalf("found blocks recorded for %v", chains)
+	}
+}
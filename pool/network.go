@@ -7,52 +7,79 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"designs.capital/dogepool/bitcoin"
 	"designs.capital/dogepool/utils"
 )
 
-const extranonce1Length = 4
+const (
+	extranonce1Length = 4 // Bytes
+	extranonce2Length = 4 // Bytes
+)
 
-var numberOfConnections int
+var (
+	numberOfConnections int32
+	ipConnections       = make(map[string]int)
+	ipConnectionsLock   sync.Mutex
+)
 
 type stratumClient struct {
-	ip          string
-	login       string
-	extranonce1 string
-	userAgent   string
+	sync.Mutex
+	ip                string
+	login             string          // First worker authorized on the session
+	authorizedWorkers map[string]bool // A connection may authorize several workers
+	extranonce1       string
+	userAgent         string
+
+	difficulty          float64 // Difficulty of the latest job sent
+	pendingDifficulty   float64 // Sent with the next job
+	minimumDifficulty   float64 // Requested by the miner through mining.configure
+	suggestedDifficulty float64 // Starting difficulty hint from the miner
+	varDiff             *varDiff
+
+	extranonceSubscribed bool
+	versionRollingMask   uint32             // Negotiated through mining.configure, 0 if not rolling
+	sentJobs             map[string]sentJob // What each job was sent with
+	sentJobOrder         []string           // Oldest first
+
+	acceptedShares  uint
+	rejectedShares  uint
+	duplicateShares map[string]uint // Worker => duplicates, also counted as rejected
 
-	sessionID     string
-	connection    net.Conn
-	streamEncoder *json.Encoder
+	sessionID  string
+	port       *stratumPort
+	connection net.Conn
+	outbound   chan []byte   // Packets waiting for the writer
+	closed     chan struct{} // Stops the writer
 }
 
 func (pool *PoolServer) listenForConnections() {
 	pool.connectionTimeout = mustParseDuration(pool.config.ConnectionTimeout)
 
-	addr, err := net.ResolveTCPAddr("tcp", ":"+pool.config.Port)
-	if err != nil {
+	for _, port := range pool.ports {
+		listener, err := port.listen()
 		panicOnError(err)
+
+		m := "Stratum listening on port %v at difficulty %v (TLS: %v)"
+		utils.LogInfof(m, port.port, port.difficulty, port.tls.CertFile != "")
+
+		go pool.acceptConnections(listener, port)
 	}
+}
 
-	server, err := net.ListenTCP("tcp", addr)
-	panicOnError(err)
+func (pool *PoolServer) acceptConnections(server net.Listener, port *stratumPort) {
 	defer server.Close()
 
 	for { // Listen for connections
-		if numberOfConnections > pool.config.MaxConnections {
-			log.Println("Maximum number of connections reached")
-			// log.Fatal("Maximum number of connections reached")
-
-			continue
-		}
-
-		con, err := server.AcceptTCP()
+		con, err := server.Accept()
 		if err != nil {
 			log.Println(err)
+			time.Sleep(acceptRetryDelay)
 			continue
 		}
-		con.SetKeepAlive(true)
 
 		ip, _, err := net.SplitHostPort(con.RemoteAddr().String())
 		if err != nil {
@@ -60,9 +87,14 @@ func (pool *PoolServer) listenForConnections() {
 			continue
 		}
 
-		log.Println("New Stratum Connection from: " + ip)
+		log.Println("New Stratum Connection from: " + ip + " on port " + port.port)
+
+		if isBanned(ip) || !markConnection(ip, time.Now()) {
+			con.Close()
+			continue
+		}
 
-		if isBanned(ip) {
+		if !pool.admitConnection(ip, port) {
 			con.Close()
 			continue
 		}
@@ -71,44 +103,111 @@ func (pool *PoolServer) listenForConnections() {
 			ip:          ip,
 			extranonce1: uniqueExtranonce(extranonce1Length * 2),
 			connection:  con,
+			port:        port,
+		}
+		if port.varDiffOptions != nil {
+			client.varDiff = newVarDiff(port.varDiffOptions)
 		}
 
 		go pool.openNewConnection(client)
+	}
+}
+
+const acceptRetryDelay = 100 * time.Millisecond
+
+// Reserves a connection slot, logging which limit was hit when there is none
+func (pool *PoolServer) admitConnection(ip string, port *stratumPort) bool {
+	connections := atomic.AddInt32(&numberOfConnections, 1)
+	if pool.config.MaxConnections > 0 && int(connections) > pool.config.MaxConnections {
+		atomic.AddInt32(&numberOfConnections, -1)
+		log.Println("Maximum number of connections reached, closing connection from: " + ip)
+		return false
+	}
 
-		numberOfConnections++
+	ipConnectionsLock.Lock()
+	if pool.config.MaxIPConnections > 0 && ipConnections[ip] >= pool.config.MaxIPConnections {
+		ipConnectionsLock.Unlock()
+		atomic.AddInt32(&numberOfConnections, -1)
+		log.Println("Maximum number of connections per IP reached, closing connection from: " + ip)
+		return false
 	}
+	ipConnections[ip]++
+	ipConnectionsLock.Unlock()
+
+	if !port.addConnection() {
+		releaseConnection(ip, nil)
+		log.Println("Maximum number of connections reached on port " + port.port + ", closing connection from: " + ip)
+		return false
+	}
+
+	return true
+}
+
+func releaseConnection(ip string, port *stratumPort) {
+	if port != nil {
+		port.removeConnection()
+	}
+
+	ipConnectionsLock.Lock()
+	ipConnections[ip]--
+	if ipConnections[ip] <= 0 {
+		delete(ipConnections, ip)
+	}
+	ipConnectionsLock.Unlock()
+
+	atomic.AddInt32(&numberOfConnections, -1)
+}
+
+// Open stratum connections across all ports
+func (pool *PoolServer) ConnectionCount() uint {
+	return uint(atomic.LoadInt32(&numberOfConnections))
 }
 
 const maxRequestSize = 1024
 
 func (pool *PoolServer) openNewConnection(client *stratumClient) {
+	defer releaseConnection(client.ip, client.port)
+	defer pool.closeSession(client)
+
+	client.outbound = make(chan []byte, outboundQueueSize)
+	client.closed = make(chan struct{})
+	go client.writePackets()
+
 	err := pool.handleStratumConnection(client)
 	utils.LogInfo(err)
-	// if err != nil {
-	// 	log.Println(err)
-	// 	removeSession(client.sessionID)
-	// 	client.connection.Close()
-	// 	numberOfConnections--
-	// }
+}
+
+// Runs on every exit from the connection handler
+func (pool *PoolServer) closeSession(client *stratumClient) {
+	pool.sessions.remove(client)
+	close(client.closed)
+	client.connection.Close()
+
+	client.Lock()
+	releaseExtranonce(client.extranonce1)
+	m := "Session %v [%v] closed with %v accepted and %v rejected shares"
+	utils.LogInfof(m, client.ip, client.login, client.acceptedShares, client.rejectedShares)
+	for worker, duplicates := range client.duplicateShares {
+		utils.LogInfof("Session %v [%v] had %v duplicate shares", client.ip, worker, duplicates)
+	}
+	client.Unlock()
 }
 
 func (pool *PoolServer) handleStratumConnection(client *stratumClient) error {
-	client.streamEncoder = json.NewEncoder(client.connection)
 	connectionBuffer := bufio.NewReaderSize(client.connection, maxRequestSize)
 
 	timeoutTime := time.Now().Add(pool.connectionTimeout)
-	client.connection.SetDeadline(timeoutTime)
+	client.connection.SetReadDeadline(timeoutTime)
 
 	for {
 		payload, isPrefix, err := connectionBuffer.ReadLine()
 		if err == io.EOF {
-			removeSession(client.sessionID)
 			return errors.New("client disconnect: " + client.ip)
 		}
 
 		if isPrefix {
 			log.Println("Socket flood detected from: " + client.ip)
-			banClient(client)
+			banClient(client, "socket flood")
 			return err
 		} else if err != nil {
 			log.Println("Socket read error from: " + client.ip + " " + err.Error())
@@ -124,8 +223,160 @@ func (pool *PoolServer) handleStratumConnection(client *stratumClient) error {
 	}
 }
 
+// Returns true for the first worker authorized on the session
+func (client *stratumClient) authorizeWorker(login string) bool {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.authorizedWorkers == nil {
+		client.authorizedWorkers = make(map[string]bool)
+	}
+	client.authorizedWorkers[login] = true
+
+	if client.login != "" {
+		return false
+	}
+	client.login = login
+	return true
+}
+
+func (client *stratumClient) isAuthorized(login string) bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.authorizedWorkers[login]
+}
+
+func (client *stratumClient) hasAuthorizedWorkers() bool {
+	client.Lock()
+	defer client.Unlock()
+	return len(client.authorizedWorkers) > 0
+}
+
+func (client *stratumClient) countShare(accepted bool) {
+	client.Lock()
+	defer client.Unlock()
+
+	if accepted {
+		client.acceptedShares++
+	} else {
+		client.rejectedShares++
+	}
+}
+
+// Returns the worker's duplicates on this session so far
+func (client *stratumClient) countDuplicate(worker string) uint {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.duplicateShares == nil {
+		client.duplicateShares = make(map[string]uint)
+	}
+	client.duplicateShares[worker]++
+	return client.duplicateShares[worker]
+}
+
+const maxRememberedSentJobs = maxFreshJobs + maxStaleJobs // As many as the job registry keeps
+
+type sentJob struct {
+	difficulty  float64
+	extranonce1 string
+}
+
+// Sends any pending difficulty, then remembers what the job went out with
+func (client *stratumClient) miningNotify(work bitcoin.Work) (stratumRequest, error) {
+	client.Lock()
+	defer client.Unlock()
+
+	jobID := work[0].(string)
+	sent, exists := client.sentJobs[jobID]
+	if exists && sent.extranonce1 != client.extranonce1 {
+		// Shares on it are still judged with the extranonce1 it first went out with
+		return stratumRequest{}, errors.New("job " + jobID + " was sent to " + client.ip + " with another extranonce1")
+	}
+
+	if client.pendingDifficulty > 0 {
+		err := sendPacket(miningSetDifficulty(client.pendingDifficulty), client)
+		if err != nil {
+			return stratumRequest{}, err
+		}
+		client.difficulty = client.pendingDifficulty
+		client.pendingDifficulty = 0
+	}
+	if client.varDiff != nil {
+		client.varDiff.start(time.Now())
+	}
+
+	if client.sentJobs == nil {
+		client.sentJobs = make(map[string]sentJob)
+	}
+	if !exists {
+		client.sentJobOrder = append(client.sentJobOrder, jobID)
+	}
+	client.sentJobs[jobID] = sentJob{
+		difficulty:  client.difficulty,
+		extranonce1: client.extranonce1,
+	}
+	if len(client.sentJobOrder) > maxRememberedSentJobs {
+		delete(client.sentJobs, client.sentJobOrder[0])
+		client.sentJobOrder = client.sentJobOrder[1:]
+	}
+
+	return miningNotify(work), nil
+}
+
+// Jobs we don't remember sending are judged with the session's current values
+func (client *stratumClient) sentJob(jobID string) sentJob {
+	client.Lock()
+	defer client.Unlock()
+
+	sent, exists := client.sentJobs[jobID]
+	if !exists {
+		return sentJob{
+			difficulty:  client.difficulty,
+			extranonce1: client.extranonce1,
+		}
+	}
+	return sent
+}
+
+const (
+	outboundQueueSize = 64
+	writeTimeout      = 10 * time.Second
+)
+
+// Queues a packet for the session's writer without ever blocking.
+// A session too slow to drain its queue is disconnected.
 func sendPacket(packet any, client *stratumClient) error {
-	return client.streamEncoder.Encode(packet)
+	payload, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+	payload = append(payload, '\n')
+
+	select {
+	case client.outbound <- payload:
+		return nil
+	default:
+		client.connection.Close() // The read loop errors out and closes the session
+		return errors.New("outbound queue overflow, disconnecting: " + client.ip)
+	}
+}
+
+func (client *stratumClient) writePackets() {
+	for {
+		select {
+		case payload := <-client.outbound:
+			client.connection.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_, err := client.connection.Write(payload)
+			if err != nil {
+				log.Println("Socket write error to: " + client.ip + " " + err.Error())
+				client.connection.Close()
+				return
+			}
+		case <-client.closed:
+			return
+		}
+	}
 }
 
 func mustParseDuration(s string) time.Duration {
@@ -1,16 +1,12 @@
 package pool
 
 import (
-	"context"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 
 	"designs.capital/dogepool/bitcoin"
 	"designs.capital/dogepool/rpc"
 	"designs.capital/dogepool/utils"
-	"github.com/go-zeromq/zmq4"
 )
 
 type BlockChainNodesMap map[string]blockChainNode // "blockChainName" => activeNode
@@ -20,8 +16,9 @@ type blockChainNode struct {
 	RPC                *rpc.RPCClient
 	ChainName          string
 	Network            string
-	RewardPubScriptKey string // TODO - this is very bitcoin specific.  Abstract to interface.
+	RewardPubScriptKey string // Primary chain only
 	RewardTo           string
+	CoinbaseRecipients []bitcoin.CoinbaseRecipient // Paid alongside RewardTo in primary blocks
 	NetworkDifficulty  float64
 }
 
@@ -48,11 +45,16 @@ func (pool *PoolServer) loadBlockchainNodes() {
 		chainInfo, err := rpcClient.GetBlockChainInfo()
 		logFatalOnError(err)
 
-		address, err := rpcClient.ValidateAddress(nodeConfig.RewardTo)
-		logFatalOnError(err)
-
-		// TODO this is wayy to bitcoin specific.  Move this to the coin package.
-		rewardPubScriptKey := address.ScriptPubKey
+		// Only primary blocks are built by the pool, aux chains pay reward_to through createauxblock
+		var rewardPubScriptKey string
+		var recipients []bitcoin.CoinbaseRecipient
+		if blockChainName == pool.config.GetPrimary() {
+			chain, err := bitcoin.GetChain(blockChainName)
+			logFatalOnError(err)
+			rewardPubScriptKey, err = bitcoin.AddressPubScriptKey(chain, chainInfo.Chain, nodeConfig.RewardTo)
+			logFatalOnError(err)
+			recipients = pool.coinbaseRecipients(chain, chainInfo.Chain)
+		}
 
 		newNode := blockChainNode{
 			NotifyURL:          nodeConfig.NotifyURL,
@@ -60,6 +62,7 @@ func (pool *PoolServer) loadBlockchainNodes() {
 			Network:            chainInfo.Chain,
 			RewardPubScriptKey: rewardPubScriptKey,
 			RewardTo:           nodeConfig.RewardTo,
+			CoinbaseRecipients: recipients,
 			NetworkDifficulty:  chainInfo.NetworkDifficulty,
 			ChainName:          blockChainName,
 		}
@@ -67,16 +70,32 @@ func (pool *PoolServer) loadBlockchainNodes() {
 	}
 }
 
+func (pool *PoolServer) coinbaseRecipients(chain bitcoin.Blockchain, network string) []bitcoin.CoinbaseRecipient {
+	blockChainName := pool.config.GetPrimary()
+	var recipients []bitcoin.CoinbaseRecipient
+	total := float64(0)
+	for _, output := range pool.config.Payouts.Chains[blockChainName].CoinbaseOutputs {
+		pubScriptKey, err := bitcoin.AddressPubScriptKey(chain, network, output.Address)
+		logFatalOnError(err)
+
+		recipients = append(recipients, bitcoin.CoinbaseRecipient{
+			PubScriptKey: pubScriptKey,
+			Percentage:   output.Percentage,
+		})
+		total += output.Percentage
+	}
+	if total >= 1 {
+		panic("coinbase_outputs for " + blockChainName + " leave nothing for reward_to")
+	}
+	return recipients
+}
+
 func (pool *PoolServer) listenForBlockNotifications() error {
 	notifyChannel := make(chan hashBlockResponse)
 	hashblockCounterMap := make(hashblockCounterMap)
 
 	for blockChainName := range pool.activeNodes {
-		subscription, err := pool.createZMQSubscriptionToHashBlock(blockChainName, notifyChannel)
-		if err != nil {
-			return err
-		}
-		defer subscription.Close()
+		pool.newBlockWatcher(blockChainName, notifyChannel).start()
 	}
 
 	for {
@@ -89,17 +108,34 @@ func (pool *PoolServer) listenForBlockNotifications() error {
 		m := "**New %v block: %v - %v**"
 		utils.LogInfof(m, chainName, newCount, prevBlockHash)
 
-		if prevCount != 0 && (prevCount+1) != newCount {
+		if prevCount != 0 && newCount != 0 && (prevCount+1) != newCount { // Polled blocks have no count
 			m = "We missed a %v block notification, previous count: %v current count: %v"
 			utils.LogInfof(m, chainName, prevCount, newCount)
 		}
 
 		hashblockCounterMap[chainName] = newCount
 
-		err := pool.fetchRpcBlockTemplatesAndCacheWork()
-		logOnError(err)
-		work, err := pool.generateWorkFromCache(true)
+		// Only a new primary block invalidates the jobs miners are working on
+		cleanJobs := false
+		var err error
+		if chainName == pool.config.GetPrimary() {
+			if pool.workBuildsOn(prevBlockHash) {
+				continue // A template refresh got to the block first
+			}
+			cleanJobs, err = pool.fetchRpcBlockTemplatesAndCacheWork()
+		} else {
+			err = pool.refreshAuxBlockAndCacheWork(chainName)
+			if err != nil {
+				logOnError(err)
+				cleanJobs, err = pool.fetchRpcBlockTemplatesAndCacheWork() // May also find a new primary block
+			}
+		}
 		logOnError(err)
+		work, err := pool.generateWorkFromCache(cleanJobs)
+		if err != nil {
+			logOnError(err)
+			continue
+		}
 		pool.broadcastWork(work)
 	}
 }
@@ -146,52 +182,6 @@ type hashBlockResponse struct {
 	blockHashCounter  uint32
 }
 
-func (p *PoolServer) createZMQSubscriptionToHashBlock(blockChainName string, hashBlockChannel chan hashBlockResponse) (zmq4.Socket, error) {
-	sub := zmq4.NewSub(context.Background())
-
-	url := p.activeNodes[blockChainName].NotifyURL
-	err := sub.Dial(url)
-	if err != nil {
-		return sub, err
-	}
-
-	err = sub.SetOption(zmq4.OptionSubscribe, "hashblock")
-	if err != nil {
-		return sub, err
-	}
-
-	logErr := func(msg zmq4.Msg, err error) zmq4.Msg {
-		if err != nil {
-			log.Println(err)
-		}
-
-		return msg
-	}
-
-	go func() {
-		for {
-			msg := logErr(sub.Recv())
-
-			if len(msg.Frames) > 2 {
-				var blockHashCounter uint32
-				blockHashCounter |= uint32(msg.Frames[2][0])
-				blockHashCounter |= uint32(msg.Frames[2][1]) << 8
-				blockHashCounter |= uint32(msg.Frames[2][2]) << 16
-				blockHashCounter |= uint32(msg.Frames[2][3]) << 24
-
-				hashBlockChannel <- hashBlockResponse{
-					blockChainName:    blockChainName,
-					previousBlockHash: hex.EncodeToString(msg.Frames[1]),
-					blockHashCounter:  blockHashCounter,
-				}
-			}
-
-		}
-	}()
-
-	return sub, nil
-}
-
 func (p *PoolServer) CheckAndRecoverRPCs() error {
 	var err error
 	for coin, manager := range p.rpcManagers {
@@ -1,25 +1,240 @@
 package pool
 
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"designs.capital/dogepool/config"
+	"designs.capital/dogepool/persistence"
+	"designs.capital/dogepool/utils"
+)
+
+// Temporarily bans IPs that flood sockets, send garbage, burst connections or mostly submit invalid shares.
+// Bans are persisted so they survive restarts.
+
+type policyOptions struct {
+	banTime               time.Duration
+	checkThreshold        int
+	invalidPercent        float64
+	malformedRequestLimit int
+	connectionBurstLimit  int
+	connectionBurstWindow time.Duration
+	sweepInterval         time.Duration
+}
+
+func makePolicyOptions(cfg config.BanningConfig) *policyOptions {
+	if cfg.BanTime == "" {
+		return nil // Banning disabled
+	}
+
+	options := &policyOptions{
+		banTime:               mustParseDuration(cfg.BanTime),
+		checkThreshold:        cfg.CheckThreshold,
+		invalidPercent:        cfg.InvalidPercent,
+		malformedRequestLimit: cfg.MalformedRequestLimit,
+		connectionBurstLimit:  cfg.ConnectionBurstLimit,
+		sweepInterval:         time.Minute,
+	}
+	if cfg.ConnectionBurstWindow != "" {
+		options.connectionBurstWindow = mustParseDuration(cfg.ConnectionBurstWindow)
+	}
+	if cfg.SweepInterval != "" {
+		options.sweepInterval = mustParseDuration(cfg.SweepInterval)
+	}
+
+	return options
+}
+
+type ipActivity struct {
+	validShares       int
+	invalidShares     int
+	malformedRequests int
+	connections       []time.Time // Within the burst window, oldest first
+}
+
+type policyEngine struct {
+	sync.Mutex
+	poolID   string
+	options  *policyOptions
+	bans     map[string]time.Time // IP => ban expiry
+	activity map[string]*ipActivity
+}
+
+var policy policyEngine
+
+func initiatePolicy(cfg *config.Config) {
+	policy.Lock()
+	defer policy.Unlock()
+
+	policy.poolID = cfg.PoolName
+	policy.options = makePolicyOptions(cfg.Banning)
+	policy.bans = make(map[string]time.Time)
+	policy.activity = make(map[string]*ipActivity)
+
+	if policy.options == nil {
+		return
+	}
+
+	bans, err := persistence.Bans.GetActiveBans(policy.poolID, time.Now())
+	logOnError(err)
+	for _, ban := range bans {
+		policy.bans[ban.IpAddress] = ban.Expires
+	}
+	utils.LogInfof("Loaded %v active IP ban(s)", len(bans))
+
+	go policy.sweepExpiredBans(policy.options.sweepInterval)
+}
+
 func isBanned(ip string) bool {
-	if len(ip) > 0 {
-		return false // TODO
+	policy.Lock()
+	defer policy.Unlock()
+
+	expires, banned := policy.bans[ip]
+	return banned && time.Now().Before(expires)
+}
+
+// Call with the policy locked
+func (p *policyEngine) ban(ip, reason string) {
+	if p.options == nil {
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(p.options.banTime)
+	p.bans[ip] = expires
+	delete(p.activity, ip)
+
+	log.Printf("Banned %v until %v: %v", ip, expires.Format(time.RFC3339), reason)
+
+	record := persistence.Ban{
+		PoolID:    p.poolID,
+		IpAddress: ip,
+		Reason:    reason,
+		Created:   now,
+		Expires:   expires,
 	}
-	return false
+	go func() { // Don't hold up the policy on the database
+		logOnError(persistence.Bans.Insert(record))
+	}()
 }
 
-// func surpassedLimitPolicy(ip string) bool {
-// 	if len(ip) > 0 {
-// 		return false // TODO
-// 	}
-// 	return false
-// }
+// Call with the policy locked
+func (p *policyEngine) ipActivity(ip string) *ipActivity {
+	activity, exists := p.activity[ip]
+	if !exists {
+		activity = &ipActivity{}
+		p.activity[ip] = activity
+	}
+	return activity
+}
 
-func banClient(client *stratumClient) {
-	removeSession(client.sessionID)
+// The connection handler deregisters the session once its connection is closed
+func banClient(client *stratumClient, reason string) {
+	policy.Lock()
+	policy.ban(client.ip, reason)
+	policy.Unlock()
 
-	// BAN IP?  BAN Miner address?
+	client.connection.Close()
 }
 
 func markMalformedRequest(client *stratumClient, jsonPayload []byte) {
+	policy.Lock()
+	defer policy.Unlock()
+
+	if policy.options == nil || policy.options.malformedRequestLimit < 1 {
+		return
+	}
+
+	activity := policy.ipActivity(client.ip)
+	activity.malformedRequests++
+	if activity.malformedRequests >= policy.options.malformedRequestLimit {
+		policy.ban(client.ip, "malformed requests")
+	}
+}
+
+// Returns an error when the share got the client banned
+func markShare(client *stratumClient, valid bool) error {
+	policy.Lock()
+	defer policy.Unlock()
+
+	if policy.options == nil || policy.options.checkThreshold < 1 {
+		return nil
+	}
+
+	activity := policy.ipActivity(client.ip)
+	if valid {
+		activity.validShares++
+	} else {
+		activity.invalidShares++
+	}
+
+	total := activity.validShares + activity.invalidShares
+	if total < policy.options.checkThreshold {
+		return nil
+	}
 
+	invalidPercent := float64(activity.invalidShares) / float64(total) * 100
+	activity.validShares = 0
+	activity.invalidShares = 0
+	if invalidPercent < policy.options.invalidPercent {
+		return nil
+	}
+
+	policy.ban(client.ip, "invalid shares")
+	return errors.New("client banned for invalid shares: " + client.ip)
+}
+
+// Returns false when the IP has connected too often within the burst window
+func markConnection(ip string, now time.Time) bool {
+	policy.Lock()
+	defer policy.Unlock()
+
+	if policy.options == nil || policy.options.connectionBurstLimit < 1 {
+		return true
+	}
+
+	activity := policy.ipActivity(ip)
+	cutoff := now.Add(-policy.options.connectionBurstWindow)
+	recent := activity.connections[:0]
+	for _, connected := range activity.connections {
+		if connected.After(cutoff) {
+			recent = append(recent, connected)
+		}
+	}
+	activity.connections = append(recent, now)
+
+	if len(activity.connections) > policy.options.connectionBurstLimit {
+		policy.ban(ip, "connection burst")
+		return false
+	}
+	return true
+}
+
+func (p *policyEngine) sweepExpiredBans(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		now := time.Now()
+
+		p.Lock()
+		for ip, expires := range p.bans {
+			if !now.Before(expires) {
+				delete(p.bans, ip)
+				utils.LogInfof("Ban expired for %v", ip)
+			}
+		}
+		// Forget activity of IPs that went quiet
+		for ip, activity := range p.activity {
+			idle := len(activity.connections) == 0 ||
+				now.Sub(activity.connections[len(activity.connections)-1]) > p.options.banTime
+			if idle && activity.validShares+activity.invalidShares == 0 {
+				delete(p.activity, ip)
+			}
+		}
+		p.Unlock()
+
+		err := persistence.Bans.DeleteExpiredBans(p.poolID, now)
+		logOnError(err)
+	}
 }
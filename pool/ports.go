@@ -0,0 +1,113 @@
+package pool
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+
+	"designs.capital/dogepool/config"
+)
+
+// Every port has its own difficulty profile, but they all share one job stream and share pipeline
+
+type stratumPort struct {
+	port           string
+	difficulty     float64
+	varDiffOptions *varDiffOptions
+	maxConnections int32
+	connections    int32
+	tls            config.TLSConfig
+}
+
+func makeStratumPorts(cfg *config.Config) []*stratumPort {
+	portConfigs := cfg.StratumPorts
+	if len(portConfigs) == 0 { // Single port setups
+		portConfigs = []config.StratumPortConfig{{
+			Port:       cfg.Port,
+			Difficulty: cfg.PoolDifficulty,
+			VarDiff:    cfg.VarDiff,
+		}}
+	}
+
+	ports := make([]*stratumPort, len(portConfigs))
+	for i, portConfig := range portConfigs {
+		difficulty := portConfig.Difficulty
+		if difficulty <= 0 {
+			difficulty = cfg.PoolDifficulty
+		}
+
+		varDiffConfig := portConfig.VarDiff
+		if varDiffConfig.TargetTime == "" {
+			varDiffConfig = cfg.VarDiff
+		}
+
+		varDiffOptions, err := makeVarDiffOptions(varDiffConfig)
+		if err != nil {
+			log.Fatalf("Port %v: %v", portConfig.Port, err)
+		}
+
+		ports[i] = &stratumPort{
+			port:           portConfig.Port,
+			difficulty:     difficulty,
+			varDiffOptions: varDiffOptions,
+			maxConnections: int32(portConfig.MaxConnections),
+			tls:            portConfig.TLS,
+		}
+	}
+
+	return ports
+}
+
+func (port *stratumPort) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", ":"+port.port)
+	if err != nil {
+		return nil, err
+	}
+
+	if port.tls.CertFile == "" {
+		return listener, nil
+	}
+
+	tlsListener, err := listenTLS(listener, port.tls)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return tlsListener, nil
+}
+
+// Returns false when the port is full
+func (port *stratumPort) addConnection() bool {
+	connections := atomic.AddInt32(&port.connections, 1)
+	if port.maxConnections > 0 && connections > port.maxConnections {
+		atomic.AddInt32(&port.connections, -1)
+		return false
+	}
+	return true
+}
+
+func (port *stratumPort) removeConnection() {
+	atomic.AddInt32(&port.connections, -1)
+}
+
+// Keeps miner difficulty hints within the port's bounds.
+// Without vardiff, hints may only raise the port difficulty.
+func (port *stratumPort) boundDifficulty(difficulty float64) float64 {
+	if port.varDiffOptions != nil {
+		return port.varDiffOptions.clamp(difficulty)
+	}
+	if difficulty < port.difficulty {
+		return port.difficulty
+	}
+	return difficulty
+}
+
+func (pool *PoolServer) hasVarDiffPorts() bool {
+	for _, port := range pool.ports {
+		if port.varDiffOptions != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -29,13 +29,19 @@ func miningNotify(work bitcoin.Work) stratumRequest {
 	return request
 }
 
-// func miningSetExtranonce(extranonce string) stratumRequest {
-// 	var request stratumRequest
-// 	utils.LogInfo("miningSetExtranonce TODO", extranonce)
-// 	// TODO build request; I need a better example
+func miningSetExtranonce(extranonce1 string, extranonce2Size int) stratumRequest {
+	var request stratumRequest
 
-// 	return request
-// }
+	request.Method = "mining.set_extranonce"
+
+	params := []any{extranonce1, extranonce2Size}
+
+	var err error
+	request.Params, err = json.Marshal(params)
+	logOnError(err)
+
+	return request
+}
 
 func miningSetDifficulty(difficulty float64) stratumRequest {
 	var request stratumRequest
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +26,28 @@ type stratumErrorResponse struct {
 	Message string `json:"message"`
 }
 
+func (e *stratumErrorResponse) Error() string {
+	return e.Message
+}
+
+var (
+	errOther          = &stratumErrorResponse{Code: 20, Message: "Other/Unknown"}
+	errJobNotFound    = &stratumErrorResponse{Code: 21, Message: "Job not found"}
+	errDuplicateShare = &stratumErrorResponse{Code: 22, Message: "Duplicate share"}
+	errLowDifficulty  = &stratumErrorResponse{Code: 23, Message: "Low difficulty share"}
+	errUnauthorized   = &stratumErrorResponse{Code: 24, Message: "Unauthorized worker"}
+	errNotSubscribed  = &stratumErrorResponse{Code: 25, Message: "Not subscribed"}
+)
+
+// Anything that isn't already a stratum error is reported as "Other/Unknown"
+func toStratumError(err error) *stratumErrorResponse {
+	var stratumErr *stratumErrorResponse
+	if errors.As(err, &stratumErr) {
+		return stratumErr
+	}
+	return errOther
+}
+
 func (pool *PoolServer) respondToStratumClient(client *stratumClient, requestPayload []byte) error {
 	var request stratumRequest
 	err := json.Unmarshal(requestPayload, &request)
@@ -35,22 +58,29 @@ func (pool *PoolServer) respondToStratumClient(client *stratumClient, requestPay
 	}
 
 	timeoutTime := time.Now().Add(pool.connectionTimeout)
-	client.connection.SetDeadline(timeoutTime)
+	client.connection.SetReadDeadline(timeoutTime)
 
 	response, err := handleStratumRequest(&request, client, pool)
 	if err != nil {
 		return err
 	}
+	if response == nil {
+		return nil // Nothing to reply, or the handler already queued its packets
+	}
 
 	return sendPacket(response, client)
 }
 
 func handleStratumRequest(request *stratumRequest, client *stratumClient, pool *PoolServer) (any, error) {
 	switch request.Method {
+	case "mining.configure":
+		return miningConfigure(request, client, pool)
 	case "mining.subscribe":
 		return miningSubscribe(request, client)
 	case "mining.authorize":
 		return miningAuthorize(request, client, pool)
+	case "mining.suggest_difficulty":
+		return miningSuggestDifficulty(request, client, pool)
 	case "mining.extranonce.subscribe":
 		return miningExtranonceSubscribe(request, client)
 	case "mining.submit":
@@ -62,10 +92,80 @@ func handleStratumRequest(request *stratumRequest, client *stratumClient, pool *
 		return nil, nil // ignored
 		// return stratumResponse{}, nil
 	default:
-		return stratumResponse{}, errors.New("unknown stratum request method: " + request.Method)
+		log.Println("Unknown stratum request method from " + client.ip + ": " + request.Method)
+		return stratumResponse{Id: request.Id, Result: false, Error: errOther}, nil
 	}
 }
 
+// BIP310 - https://github.com/slushpool/stratumprotocol/blob/master/stratum-extensions.mediawiki
+func miningConfigure(request *stratumRequest, client *stratumClient, pool *PoolServer) (stratumResponse, error) {
+	response := stratumResponse{
+		Id:     request.Id,
+		Result: interface{}(false),
+	}
+
+	var params []json.RawMessage
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil || len(params) < 1 {
+		response.Error = errOther
+		return response, nil
+	}
+
+	var extensions []string
+	err = json.Unmarshal(params[0], &extensions)
+	if err != nil {
+		response.Error = errOther
+		return response, nil
+	}
+
+	extensionParams := make(map[string]any)
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &extensionParams)
+	}
+
+	result := make(map[string]any)
+	for _, extension := range extensions {
+		switch extension {
+		case "version-rolling":
+			minerMask := uint64(0xffffffff)
+			if maskHex, ok := extensionParams["version-rolling.mask"].(string); ok {
+				minerMask, err = strconv.ParseUint(maskHex, 16, 32)
+				if err != nil {
+					result[extension] = false
+					continue
+				}
+			}
+			mask := pool.versionRollingMask & uint32(minerMask)
+
+			client.Lock()
+			client.versionRollingMask = mask
+			client.Unlock()
+
+			result[extension] = mask != 0
+			result["version-rolling.mask"] = fmt.Sprintf("%08x", mask)
+		case "minimum-difficulty":
+			difficulty, ok := extensionParams["minimum-difficulty.value"].(float64)
+			if !ok || difficulty < 0 {
+				result[extension] = false
+				continue
+			}
+			client.setMinimumDifficulty(difficulty)
+			result[extension] = true
+		case "subscribe-extranonce":
+			client.Lock()
+			client.extranonceSubscribed = true
+			client.Unlock()
+			result[extension] = true
+		default:
+			result[extension] = false
+		}
+	}
+
+	response.Result = result
+
+	return response, nil
+}
+
 func miningSubscribe(request *stratumRequest, client *stratumClient) (stratumResponse, error) {
 	var response stratumResponse
 
@@ -92,7 +192,7 @@ func miningSubscribe(request *stratumRequest, client *stratumClient) (stratumRes
 	difficulty := interface{}([]string{"mining.set_difficulty", client.sessionID})
 	notify := interface{}([]string{"mining.notify", client.sessionID})
 	extranonce1 := interface{}(client.extranonce1)
-	extranonce2Length := interface{}(4)
+	extranonce2Size := interface{}(extranonce2Length)
 
 	subscriptions = append(subscriptions, difficulty)
 	subscriptions = append(subscriptions, notify)
@@ -100,7 +200,7 @@ func miningSubscribe(request *stratumRequest, client *stratumClient) (stratumRes
 	var responseResult []interface{}
 	responseResult = append(responseResult, subscriptions)
 	responseResult = append(responseResult, extranonce1)
-	responseResult = append(responseResult, extranonce2Length)
+	responseResult = append(responseResult, extranonce2Size)
 
 	response.Id = request.Id
 	response.Result = responseResult
@@ -115,18 +215,22 @@ func miningAuthorize(request *stratumRequest, client *stratumClient, pool *PoolS
 		return reply, errors.New("banned client attempted to access: " + client.ip)
 	}
 
+	authResponse := stratumResponse{
+		Result: interface{}(false),
+		Id:     request.Id,
+	}
+
 	var params []string
 	err := json.Unmarshal(request.Params, &params)
-	if err != nil {
-		return reply, err
-	}
-	if len(params) < 1 {
-		return reply, errors.New("invalid parameters")
+	if err != nil || len(params) < 1 {
+		log.Println("Invalid mining.authorize parameters from " + client.ip)
+		authResponse.Error = errOther
+		return authResponse, nil
 	}
 
-	authResponse := stratumResponse{
-		Result: interface{}(false),
-		Id:     request.Id,
+	if client.sessionID == "" {
+		authResponse.Error = errNotSubscribed
+		return authResponse, nil
 	}
 
 	loginString := params[0]
@@ -134,8 +238,10 @@ func miningAuthorize(request *stratumRequest, client *stratumClient, pool *PoolS
 	minerAddressesString := loginParts[0]
 	// minerAddressString format: primarycoinAddress-auxcoinAddress-auxcoinAddress.rigID
 	minerAddresses := strings.Split(minerAddressesString, "-")
-	if len(minerAddresses) != len(pool.config.BlockChainOrder) {
-		return authResponse, errors.New("not enough miner addresses to login")
+	if len(minerAddresses) != len(pool.config.BlockChainOrder) || len(loginParts) < 2 {
+		log.Println("Not enough miner addresses to login from " + client.ip + ": " + loginString)
+		authResponse.Error = errUnauthorized
+		return authResponse, nil
 	}
 
 	rigID := loginParts[1]
@@ -143,15 +249,21 @@ func miningAuthorize(request *stratumRequest, client *stratumClient, pool *PoolS
 	// The config has the primarycoinAddress-auxcoinAddress-auxcoinAddress order we need
 	blockchainIndex := 0
 	for _, blockChainName := range pool.config.BlockChainOrder {
-		blockChain := bitcoin.GetChain(blockChainName)
+		blockChain, err := bitcoin.GetChain(blockChainName)
+		if err != nil {
+			utils.LogError(err)
+			authResponse.Error = errOther
+			return authResponse, nil
+		}
 		inputBlockChainAddress := minerAddresses[blockchainIndex]
 
 		network := pool.activeNodes[blockChainName].Network
-		if (network == "test" && !blockChain.ValidTestnetAddress(inputBlockChainAddress)) ||
-			(network == "main" && !blockChain.ValidMainnetAddress(inputBlockChainAddress)) {
-			m := "invalid %v %vnet miner address from %v: %v"
+		if !bitcoin.ValidAddress(blockChain, network, inputBlockChainAddress) {
+			m := "invalid %v (%v) miner address from %v: %v"
 			m = fmt.Sprintf(m, blockChainName, network, client.ip, inputBlockChainAddress)
-			return authResponse, errors.New(m)
+			log.Println(m)
+			authResponse.Error = errUnauthorized
+			return authResponse, nil
 		}
 
 		blockchainIndex++
@@ -159,36 +271,114 @@ func miningAuthorize(request *stratumRequest, client *stratumClient, pool *PoolS
 
 	utils.LogInfof("Authorized rig: %v mining to addresses: %v", rigID, minerAddresses)
 
-	client.login = loginString
+	if len(params) > 1 {
+		difficulty, exists := passwordDifficulty(params[1])
+		if exists {
+			client.suggestDifficulty(client.port.boundDifficulty(difficulty))
+		}
+	}
 
-	addSession(client)
+	firstWorker := client.authorizeWorker(loginString)
 
 	authResponse.Result = interface{}(true)
 
+	if !firstWorker { // Extra workers share the session's difficulty and work
+		return authResponse, nil
+	}
+
 	err = sendPacket(authResponse, client) // Mining.Auth replies with three packets (1)
 	if err != nil {
 		return reply, err
 	}
 
-	err = sendPacket(miningSetDifficulty(pool.config.PoolDifficulty), client) // Mining.Auth replies with three packets (2)
+	work, err := pool.generateWorkFromCache(false)
 	if err != nil {
 		return reply, err
 	}
 
-	work, err := pool.generateWorkFromCache(false)
+	client.Lock()
+	startingDifficulty := client.suggestedDifficulty
+	client.Unlock()
+	if startingDifficulty == 0 {
+		startingDifficulty = client.port.difficulty
+	}
+
+	client.setDifficulty(startingDifficulty)
+	reply, err = client.miningNotify(work) // Mining.Auth replies with three packets (2 & 3)
 	if err != nil {
 		return reply, err
 	}
 
-	reply = miningNotify(work) // Mining.Auth replies with three packets (3)
+	// Queue the first notify before broadcasts can reach the session, so no job goes out without a difficulty
+	err = sendPacket(reply, client)
+	if err != nil {
+		return nil, err
+	}
+	pool.sessions.add(client)
+
+	// A broadcast may have gone out between building our job and registering the session
+	latest, err := pool.generateWorkFromCache(true)
+	if err != nil {
+		return nil, err
+	}
+	if latest[0] != work[0] {
+		request, err := client.miningNotify(latest)
+		if err != nil {
+			return nil, err
+		}
+		return request, nil
+	}
+
+	return nil, nil
+}
 
-	return reply, nil
+// Password format: d=65536, optionally among other comma separated options
+func passwordDifficulty(password string) (float64, bool) {
+	options := strings.FieldsFunc(password, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+	for _, option := range options {
+		value, found := strings.CutPrefix(option, "d=")
+		if !found {
+			continue
+		}
+		difficulty, err := strconv.ParseFloat(value, 64)
+		if err != nil || difficulty <= 0 {
+			return 0, false
+		}
+		return difficulty, true
+	}
+	return 0, false
+}
+
+func miningSuggestDifficulty(request *stratumRequest, client *stratumClient, pool *PoolServer) (stratumResponse, error) {
+	response := stratumResponse{
+		Id:     request.Id,
+		Result: interface{}(false),
+	}
+
+	var params []float64
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil || len(params) < 1 || params[0] <= 0 {
+		response.Error = errOther
+		return response, nil
+	}
+
+	client.suggestDifficulty(client.port.boundDifficulty(params[0]))
+	response.Result = interface{}(true)
+
+	return response, nil
 }
 
 func miningExtranonceSubscribe(request *stratumRequest, client *stratumClient) (stratumResponse, error) {
-	var response stratumResponse
-	utils.LogInfo("miningExtranonceSubscribe", request, client)
-	// TODO - I need to find a good example for this one
+	response := stratumResponse{
+		Id:     request.Id,
+		Result: interface{}(true),
+	}
+
+	client.Lock()
+	client.extranonceSubscribed = true
+	client.Unlock()
 
 	return response, nil
 }
@@ -199,17 +389,34 @@ func miningSubmit(request *stratumRequest, client *stratumClient, pool *PoolServ
 		Id:     request.Id,
 	}
 
+	if client.sessionID == "" {
+		response.Error = errNotSubscribed
+		return response, nil
+	}
+	if !client.hasAuthorizedWorkers() {
+		response.Error = errUnauthorized
+		return response, nil
+	}
+
 	var work bitcoin.Work
 	err := json.Unmarshal(request.Params, &work)
+	if err == nil {
+		err = pool.recieveWorkFromClient(work, client)
+	}
 	if err != nil {
-		return response, err
+		response.Error = toStratumError(err)
+		if response.Error == errOther {
+			utils.LogError(err)
+		}
+		client.countShare(false)
+		return response, markShare(client, false)
 	}
 
-	err = pool.recieveWorkFromClient(work, client)
+	client.countShare(true)
+	err = markShare(client, true)
 	if err != nil {
-		utils.LogError(err)
+		return response, err
 	}
-
 	response.Result = interface{}(true)
 
 	return response, nil
@@ -0,0 +1,51 @@
+package pool
+
+import (
+	"encoding/json"
+	"testing"
+
+	"designs.capital/dogepool/config"
+)
+
+func TestMiningAuthorizeRepliesWithStratumErrors(t *testing.T) {
+	pool := &PoolServer{config: &config.Config{BlockChainOrder: config.BlockChainOrder{"unregisteredcoin"}}}
+
+	tests := []struct {
+		name      string
+		sessionID string
+		params    string
+		err       *stratumErrorResponse
+	}{
+		{"params not an array", "session", `{"user":"x"}`, errOther},
+		{"params not strings", "session", `[1, 2]`, errOther},
+		{"no params", "session", `[]`, errOther},
+		{"not subscribed", "", `["address.rig"]`, errNotSubscribed},
+		{"no rig", "session", `["address"]`, errUnauthorized},
+		{"unknown chain", "session", `["address.rig"]`, errOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &stratumClient{ip: "192.0.2.1", sessionID: test.sessionID}
+			request := &stratumRequest{Id: json.RawMessage("7"), Method: "mining.authorize", Params: json.RawMessage(test.params)}
+
+			reply, err := miningAuthorize(request, client, pool)
+			if err != nil {
+				t.Fatalf("got error %v, the session would be closed", err)
+			}
+			response, ok := reply.(stratumResponse)
+			if !ok {
+				t.Fatalf("got %T, want a stratumResponse", reply)
+			}
+			if response.Error != test.err {
+				t.Fatalf("got error %v, want %v", response.Error, test.err)
+			}
+			if response.Result != false || string(response.Id) != "7" {
+				t.Fatalf("got result %v for id %s", response.Result, response.Id)
+			}
+			if client.hasAuthorizedWorkers() {
+				t.Fatal("worker authorized")
+			}
+		})
+	}
+}
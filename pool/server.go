@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 
@@ -15,13 +16,20 @@ import (
 
 type PoolServer struct {
 	sync.RWMutex
-	config            *config.Config
-	activeNodes       BlockChainNodesMap
-	rpcManagers       map[string]*rpc.Manager
-	connectionTimeout time.Duration
-	templates         Pair
-	workCache         bitcoin.Work
-	shareBuffer       []persistence.Share
+	config             *config.Config
+	activeNodes        BlockChainNodesMap
+	rpcManagers        map[string]*rpc.Manager
+	connectionTimeout  time.Duration
+	ports              []*stratumPort
+	staleShareGrace    time.Duration
+	versionRollingMask uint32
+	templates          Pair
+	workCache          bitcoin.Work
+	workCacheLock      sync.RWMutex // Sessions read the cache while templates are fetched
+	workLock           sync.Mutex   // Serializes template fetches
+	jobs               jobRegistry
+	sessions           sessionRegistry
+	shareBuffer        []persistence.Share
 }
 
 func NewServer(cfg *config.Config, rpcManagers map[string]*rpc.Manager) *PoolServer {
@@ -38,13 +46,24 @@ func NewServer(cfg *config.Config, rpcManagers map[string]*rpc.Manager) *PoolSer
 	pool := &PoolServer{
 		config:      cfg,
 		rpcManagers: rpcManagers,
+		ports:       makeStratumPorts(cfg),
+		sessions:    makeSessionRegistry(),
+	}
+	if cfg.StaleShareGrace != "" {
+		pool.staleShareGrace = mustParseDuration(cfg.StaleShareGrace)
+	}
+	pool.versionRollingMask = defaultVersionRollingMask
+	if cfg.VersionRollingMask != "" {
+		mask, err := strconv.ParseUint(cfg.VersionRollingMask, 16, 32)
+		panicOnError(err)
+		pool.versionRollingMask = uint32(mask)
 	}
 
 	return pool
 }
 
 func (pool *PoolServer) Start() {
-	initiateSessions()
+	initiatePolicy(pool.config)
 	pool.loadBlockchainNodes()
 	pool.startBufferManager()
 
@@ -52,20 +71,35 @@ func (pool *PoolServer) Start() {
 	pool.templates.AuxBlocks = make([]*bitcoin.AuxBlock, amountOfChains)
 
 	// Initial work creation
-	panicOnError(pool.fetchRpcBlockTemplatesAndCacheWork())
+	_, err := pool.fetchRpcBlockTemplatesAndCacheWork()
+	panicOnError(err)
 	work, err := pool.generateWorkFromCache(false)
 	panicOnError(err)
 
 	go pool.listenForConnections()
+	go pool.restartExtranonceAllocatorOnSignal()
+	if pool.hasVarDiffPorts() {
+		go pool.retargetIdleSessions()
+	}
 	pool.broadcastWork(work)
 
 	// There after..
+	if pool.config.JobRefreshInterval != "" {
+		go pool.refreshWorkOnInterval(mustParseDuration(pool.config.JobRefreshInterval))
+	}
 	panicOnError(pool.listenForBlockNotifications())
 }
 
+func (pool *PoolServer) refreshWorkOnInterval(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		err := pool.refreshRpcBlockTemplatesAndBroadcastWork()
+		logOnError(err)
+	}
+}
+
 func (pool *PoolServer) broadcastWork(work bitcoin.Work) {
-	request := miningNotify(work)
-	err := notifyAllSessions(request)
+	err := pool.notifyAllSessions(work)
 	logOnError(err)
 }
 
@@ -88,24 +122,13 @@ func (p *PoolServer) fetchAllBlockTemplatesFromRPC() (bitcoin.Template, []*bitco
 		if i == 0 {
 			continue
 		} else {
-			var auxBlock bitcoin.AuxBlock
-			if p.config.GetAuxN(1) != "" {
-				response, err = p.GetAuxNNode(i).RPC.CreateAuxBlock(p.GetAuxNNode(i).RewardTo)
-				if err != nil {
-					log.Printf("No aux %s block found: %s", chainName, err.Error())
-					return template, nil, nil
-				}
-
-				err = json.Unmarshal(response, &auxBlock)
-				if err != nil {
-					return template, nil, err
-				}
-				if len(auxBlock.Target2) > 0 {
-					auxBlock.Target = auxBlock.Target2
-				}
-				// utils.LogInfof("Aux1 %+v", auxBlock)
-				auxblocks = append(auxblocks, &auxBlock)
+			auxBlock, err := p.fetchAuxBlockFromRPC(i)
+			if err != nil {
+				log.Printf("No aux %s block found: %s", chainName, err.Error())
+				return template, nil, nil
 			}
+			// utils.LogInfof("Aux1 %+v", auxBlock)
+			auxblocks = append(auxblocks, auxBlock)
 		}
 	}
 
@@ -115,12 +138,49 @@ func (p *PoolServer) fetchAllBlockTemplatesFromRPC() (bitcoin.Template, []*bitco
 	return template, auxblocks, nil
 }
 
-func notifyAllSessions(request stratumRequest) error {
-	for _, client := range sessions {
-		err := sendPacket(request, client)
+func (p *PoolServer) fetchAuxBlockFromRPC(n int) (*bitcoin.AuxBlock, error) {
+	var auxBlock bitcoin.AuxBlock
+	response, err := p.GetAuxNNode(n).RPC.CreateAuxBlock(p.GetAuxNNode(n).RewardTo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(response, &auxBlock)
+	if err != nil {
+		return nil, err
+	}
+	if len(auxBlock.Target2) > 0 {
+		auxBlock.Target = auxBlock.Target2
+	}
+
+	// The chain ID picks the aux block's slot in the merged mining tree
+	chainName := p.config.BlockChainOrder[n]
+	chain, err := bitcoin.GetChain(chainName)
+	if err != nil {
+		return nil, err
+	}
+	chainID := int(chain.AuxPowChainID())
+	if auxBlock.ChainID == 0 {
+		auxBlock.ChainID = chainID
+	} else if chainID != 0 && auxBlock.ChainID != chainID {
+		log.Printf("%v daemon sent chain ID %v, expected %v", chainName, auxBlock.ChainID, chainID)
+	}
+
+	return &auxBlock, nil
+}
+
+func (pool *PoolServer) notifyAllSessions(work bitcoin.Work) error {
+	clients := pool.sessions.all()
+	for _, client := range clients {
+		request, err := client.miningNotify(work)
+		if err != nil {
+			logOnError(err)
+			continue
+		}
+		err = sendPacket(request, client)
 		logOnError(err)
 	}
-	// log.Printf("Sent work to %v client(s)", len(sessions))
+	// log.Printf("Sent work to %v client(s)", len(clients))
 	return nil
 }
 
@@ -1,17 +1,40 @@
 package pool
 
-type sessionMap map[string]*stratumClient
+import "sync"
 
-var sessions sessionMap
+// Authorized sessions receiving work broadcasts
 
-func initiateSessions() {
-	sessions = make(sessionMap)
+type sessionRegistry struct {
+	sync.RWMutex
+	sessions map[*stratumClient]struct{}
 }
 
-func addSession(client *stratumClient) {
-	sessions[client.sessionID] = client
+func makeSessionRegistry() sessionRegistry {
+	return sessionRegistry{
+		sessions: make(map[*stratumClient]struct{}),
+	}
 }
 
-func removeSession(sessionID string) {
-	delete(sessions, sessionID)
+func (r *sessionRegistry) add(client *stratumClient) {
+	r.Lock()
+	defer r.Unlock()
+	r.sessions[client] = struct{}{}
+}
+
+func (r *sessionRegistry) remove(client *stratumClient) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.sessions, client)
+}
+
+// Copy of the current sessions, safe to use while sessions come and go
+func (r *sessionRegistry) all() []*stratumClient {
+	r.RLock()
+	defer r.RUnlock()
+
+	clients := make([]*stratumClient, 0, len(r.sessions))
+	for client := range r.sessions {
+		clients = append(clients, client)
+	}
+	return clients
 }
@@ -1,6 +1,9 @@
 package pool
 
 import (
+	"encoding/hex"
+	"strconv"
+
 	"designs.capital/dogepool/bitcoin"
 )
 
@@ -10,6 +13,99 @@ const (
 	shareCandidate
 )
 
+const (
+	minSubmitParams   = 5
+	nonceLength       = 8    // Hex characters
+	nonceTimeLength   = 8    // Hex characters
+	maxNonceTimeDrift = 7200 // Seconds ntime may run ahead of the template, same as the consensus rule
+
+	defaultVersionRollingMask = 0x1fffe000 // BIP320 general purpose bits
+)
+
+var (
+	errMalformedShare      = &stratumErrorResponse{Code: 20, Message: "Malformed share"}
+	errNonceTimeOutOfRange = &stratumErrorResponse{Code: 20, Message: "ntime out of range"}
+	errInvalidVersionBits  = &stratumErrorResponse{Code: 20, Message: "Invalid version bits"}
+)
+
+// mining.submit params are all strings; anything else is malformed
+func parseSubmission(share bitcoin.Work) ([]string, error) {
+	if len(share) < minSubmitParams {
+		return nil, errMalformedShare
+	}
+
+	params := make([]string, len(share))
+	for i, param := range share {
+		value, ok := param.(string)
+		if !ok {
+			return nil, errMalformedShare
+		}
+		params[i] = value
+	}
+
+	return params, nil
+}
+
+// Checked before any hashing happens
+func validateSubmission(block *bitcoin.BitcoinBlock, params []string) error {
+	extranonce2Slot, _ := block.Extranonce2SubmissionSlot()
+	if !isHexOfLength(params[extranonce2Slot], extranonce2Length*2) {
+		return errMalformedShare
+	}
+
+	if !isHexOfLength(params[block.NonceSubmissionSlot()], nonceLength) {
+		return errMalformedShare
+	}
+
+	nonceTimeHex := params[block.NonceTimeSubmissionSlot()]
+	if !isHexOfLength(nonceTimeHex, nonceTimeLength) {
+		return errMalformedShare
+	}
+
+	nonceTime, err := strconv.ParseUint(nonceTimeHex, 16, 32)
+	if err != nil {
+		return errMalformedShare
+	}
+	templateTime := uint64(block.Template.CurrentTime)
+	if nonceTime < templateTime || nonceTime > templateTime+maxNonceTimeDrift {
+		return errNonceTimeOutOfRange
+	}
+
+	return nil
+}
+
+// Applies rolled version bits (BIP310) to the job's version
+func submittedVersion(block *bitcoin.BitcoinBlock, params []string, versionRollingMask uint32) (uint, error) {
+	version := block.Template.Version
+	versionBitsSlot, exists := block.VersionBitsSubmissionSlot()
+	if !exists || len(params) <= versionBitsSlot {
+		return version, nil
+	}
+
+	if !isHexOfLength(params[versionBitsSlot], 8) {
+		return 0, errMalformedShare
+	}
+	versionBits, err := strconv.ParseUint(params[versionBitsSlot], 16, 32)
+	if err != nil {
+		return 0, errMalformedShare
+	}
+
+	// Also catches sessions that never negotiated version rolling
+	if uint32(versionBits)&^versionRollingMask != 0 {
+		return 0, errInvalidVersionBits
+	}
+
+	return (version &^ uint(versionRollingMask)) | uint(versionBits), nil
+}
+
+func isHexOfLength(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
 func validateAndWeighShare(primary *bitcoin.BitcoinBlock, poolDifficulty float64) (int, []bool, float64) {
 	primarySum, err := primary.Sum()
 	logOnError(err)
@@ -17,7 +113,7 @@ func validateAndWeighShare(primary *bitcoin.BitcoinBlock, poolDifficulty float64
 	primaryTarget := bitcoin.Target(primary.Template.Target)
 	primaryTargetBig, _ := primaryTarget.ToBig()
 
-	poolTarget, _ := bitcoin.TargetFromDifficulty(poolDifficulty / primary.ShareMultiplier())
+	poolTarget, _ := primary.TargetFromDifficulty(poolDifficulty)
 	shareDifficulty, _ := poolTarget.ToDifficulty()
 
 	candidate := make([]bool, len(primary.Template.AuxBlocks)+1)
@@ -0,0 +1,96 @@
+package pool
+
+import (
+	"testing"
+
+	"designs.capital/dogepool/bitcoin"
+)
+
+// Same order as recieveWorkFromClient
+func checkSubmission(block *bitcoin.BitcoinBlock, share bitcoin.Work, versionRollingMask uint32) (uint, error) {
+	params, err := parseSubmission(share)
+	if err != nil {
+		return 0, err
+	}
+	err = validateSubmission(block, params)
+	if err != nil {
+		return 0, err
+	}
+	return submittedVersion(block, params, versionRollingMask)
+}
+
+func TestSubmissionErrors(t *testing.T) {
+	block := &bitcoin.BitcoinBlock{Template: &bitcoin.Template{
+		Version:     0x20000000,
+		CurrentTime: 0x6530c000,
+	}}
+
+	// worker, job ID, extranonce2, ntime, nonce and optionally version bits
+	submission := func(params ...any) bitcoin.Work {
+		return append(bitcoin.Work{"address.rig", "1a"}, params...)
+	}
+
+	tests := []struct {
+		name    string
+		share   bitcoin.Work
+		mask    uint32
+		err     *stratumErrorResponse
+		version uint
+	}{
+		{"valid", submission("00000001", "6530c000", "0000abcd"), 0, nil, 0x20000000},
+		{"latest ntime", submission("00000001", "6530dc20", "0000abcd"), 0, nil, 0x20000000},
+		{"upper case hex", submission("ABCDEF01", "6530C000", "0000ABCD"), 0, nil, 0x20000000},
+		{"rolled version", submission("00000001", "6530c000", "0000abcd", "00002000"), defaultVersionRollingMask, nil, 0x20002000},
+		{"all rollable bits", submission("00000001", "6530c000", "0000abcd", "1fffe000"), defaultVersionRollingMask, nil, 0x3fffe000},
+
+		{"no params", bitcoin.Work{}, 0, errMalformedShare, 0},
+		{"too few params", submission("00000001", "6530c000"), 0, errMalformedShare, 0},
+		{"number param", submission("00000001", 0x6530c000, "0000abcd"), 0, errMalformedShare, 0},
+		{"null param", submission(nil, "6530c000", "0000abcd"), 0, errMalformedShare, 0},
+		{"array param", submission("00000001", "6530c000", []any{"0000abcd"}), 0, errMalformedShare, 0},
+
+		{"short extranonce2", submission("000001", "6530c000", "0000abcd"), 0, errMalformedShare, 0},
+		{"long extranonce2", submission("0000000001", "6530c000", "0000abcd"), 0, errMalformedShare, 0},
+		{"non hex extranonce2", submission("0000000g", "6530c000", "0000abcd"), 0, errMalformedShare, 0},
+		{"short nonce", submission("00000001", "6530c000", "00abcd"), 0, errMalformedShare, 0},
+		{"long nonce", submission("00000001", "6530c000", "000000abcd"), 0, errMalformedShare, 0},
+		{"non hex nonce", submission("00000001", "6530c000", "0000abcx"), 0, errMalformedShare, 0},
+		{"short ntime", submission("00000001", "6530c00", "0000abcd"), 0, errMalformedShare, 0},
+		{"non hex ntime", submission("00000001", "6530c00z", "0000abcd"), 0, errMalformedShare, 0},
+		{"empty ntime", submission("00000001", "", "0000abcd"), 0, errMalformedShare, 0},
+
+		{"ntime before the template", submission("00000001", "6530bfff", "0000abcd"), 0, errNonceTimeOutOfRange, 0},
+		{"ntime past the window", submission("00000001", "6530dc21", "0000abcd"), 0, errNonceTimeOutOfRange, 0},
+		{"ntime far ahead", submission("00000001", "ffffffff", "0000abcd"), 0, errNonceTimeOutOfRange, 0},
+
+		{"version bits outside the mask", submission("00000001", "6530c000", "0000abcd", "20000000"), defaultVersionRollingMask, errInvalidVersionBits, 0},
+		{"version bits partly outside the mask", submission("00000001", "6530c000", "0000abcd", "00003000"), defaultVersionRollingMask, errInvalidVersionBits, 0},
+		{"version bits without negotiation", submission("00000001", "6530c000", "0000abcd", "00002000"), 0, errInvalidVersionBits, 0},
+		{"version bits outside a narrower mask", submission("00000001", "6530c000", "0000abcd", "00004000"), 0x00002000, errInvalidVersionBits, 0},
+		{"short version bits", submission("00000001", "6530c000", "0000abcd", "2000"), defaultVersionRollingMask, errMalformedShare, 0},
+		{"non hex version bits", submission("00000001", "6530c000", "0000abcd", "0000200g"), defaultVersionRollingMask, errMalformedShare, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := checkSubmission(block, test.share, test.mask)
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if version != test.version {
+					t.Fatalf("version: got %08x, want %08x", version, test.version)
+				}
+				return
+			}
+
+			stratumErr, ok := err.(*stratumErrorResponse)
+			if !ok {
+				t.Fatalf("got %T %v, want %v", err, err, test.err)
+			}
+			if stratumErr != test.err {
+				t.Fatalf("got %v (%v), want %v (%v)", stratumErr.Message, stratumErr.Code, test.err.Message, test.err.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package pool
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"designs.capital/dogepool/config"
+	"designs.capital/dogepool/utils"
+)
+
+// Certificates are reloaded on SIGHUP.  Sessions keep their existing TLS connection,
+// only new handshakes get the new certificate.
+
+type certificateReloader struct {
+	sync.RWMutex
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+}
+
+func newCertificateReloader(tlsConfig config.TLSConfig) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: tlsConfig.CertFile,
+		keyFile:  tlsConfig.KeyFile,
+	}
+
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (r *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.certificate = &certificate
+	r.Unlock()
+
+	return nil
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.certificate, nil
+}
+
+// Subscribes before returning, so a SIGHUP right after can't kill the pool
+func (r *certificateReloader) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			err := r.reload()
+			if err != nil {
+				// Keep serving the previous certificate
+				utils.LogErrorf("Failed to reload TLS certificate %v: %v", r.certFile, err)
+				continue
+			}
+			utils.LogInfof("Reloaded TLS certificate %v", r.certFile)
+		}
+	}()
+}
+
+func listenTLS(listener net.Listener, tlsConfig config.TLSConfig) (net.Listener, error) {
+	reloader, err := newCertificateReloader(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	reloader.reloadOnSignal()
+
+	serverConfig := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	return tls.NewListener(listener, serverConfig), nil
+}
@@ -0,0 +1,194 @@
+package pool
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"designs.capital/dogepool/config"
+)
+
+func writeSelfSignedCertificate(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = os.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Echoes lines back, like a session that stays connected
+func serveEcho(listener net.Listener) {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer connection.Close()
+			io.Copy(connection, connection)
+		}()
+	}
+}
+
+func dialTLS(t *testing.T, address string) (*tls.Conn, int64) {
+	t.Helper()
+
+	connection, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true}) // Self signed
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := connection.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	return connection, serial
+}
+
+func assertEcho(t *testing.T, connection *tls.Conn, line string) {
+	t.Helper()
+
+	connection.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := connection.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bufio.NewReader(connection).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != line+"\n" {
+		t.Fatalf("echo: got %q, want %q", reply, line)
+	}
+}
+
+func TestListenTLSReloadsCertificateOnSIGHUP(t *testing.T) {
+	directory := t.TempDir()
+	tlsConfig := config.TLSConfig{
+		CertFile: filepath.Join(directory, "cert.pem"),
+		KeyFile:  filepath.Join(directory, "key.pem"),
+	}
+	writeSelfSignedCertificate(t, tlsConfig.CertFile, tlsConfig.KeyFile, 1)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listenTLS(tcpListener, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveEcho(listener)
+
+	existing, serial := dialTLS(t, listener.Addr().String())
+	defer existing.Close()
+	if serial != 1 {
+		t.Fatalf("first handshake: got certificate %v, want 1", serial)
+	}
+	assertEcho(t, existing, "before reload")
+
+	writeSelfSignedCertificate(t, tlsConfig.CertFile, tlsConfig.KeyFile, 2)
+	if err = syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reload happens on the signal goroutine
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fresh, serial := dialTLS(t, listener.Addr().String())
+		fresh.Close()
+		if serial == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new handshakes still get certificate %v after SIGHUP", serial)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertEcho(t, existing, "after reload")
+	if serial := existing.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+		t.Fatalf("existing connection: got certificate %v, want 1", serial)
+	}
+}
+
+func TestCertificateReloadKeepsServingOnBadFiles(t *testing.T) {
+	directory := t.TempDir()
+	tlsConfig := config.TLSConfig{
+		CertFile: filepath.Join(directory, "cert.pem"),
+		KeyFile:  filepath.Join(directory, "key.pem"),
+	}
+	writeSelfSignedCertificate(t, tlsConfig.CertFile, tlsConfig.KeyFile, 1)
+
+	reloader, err := newCertificateReloader(tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(tlsConfig.KeyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = reloader.reload(); err == nil {
+		t.Fatal("reload accepted a broken key file")
+	}
+
+	certificate, err := reloader.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Int64() != 1 {
+		t.Fatalf("got certificate %v after a failed reload, want 1", leaf.SerialNumber)
+	}
+
+	writeSelfSignedCertificate(t, tlsConfig.CertFile, tlsConfig.KeyFile, 2)
+	if err = reloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ = reloader.getCertificate(nil)
+	leaf, _ = x509.ParseCertificate(certificate.Certificate[0])
+	if leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("got certificate %v after reload, want 2", leaf.SerialNumber)
+	}
+}
@@ -0,0 +1,277 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"designs.capital/dogepool/config"
+	"designs.capital/dogepool/utils"
+)
+
+// Retargets a session's difficulty so it submits a share about every targetTime.
+// New difficulties are only applied with the next job sent to the session.
+// The clock starts with the first job, so sessions that never find a share are lowered too.
+
+type varDiffOptions struct {
+	minDifficulty   float64
+	maxDifficulty   float64
+	targetTime      time.Duration
+	retargetTime    time.Duration
+	variancePercent float64
+}
+
+func makeVarDiffOptions(cfg config.VarDiffConfig) (*varDiffOptions, error) {
+	if cfg.TargetTime == "" {
+		return nil, nil // Vardiff disabled, everyone stays on the pool difficulty
+	}
+	if cfg.RetargetTime == "" {
+		return nil, errors.New("vardiff: retarget_time is required with target_time")
+	}
+
+	targetTime, err := time.ParseDuration(cfg.TargetTime)
+	if err != nil {
+		return nil, errors.New("vardiff: invalid target_time: " + err.Error())
+	}
+	retargetTime, err := time.ParseDuration(cfg.RetargetTime)
+	if err != nil {
+		return nil, errors.New("vardiff: invalid retarget_time: " + err.Error())
+	}
+	if targetTime <= 0 || retargetTime <= 0 {
+		return nil, errors.New("vardiff: target_time and retarget_time must be above 0")
+	}
+
+	options := &varDiffOptions{
+		minDifficulty:   cfg.MinDifficulty,
+		maxDifficulty:   cfg.MaxDifficulty,
+		targetTime:      targetTime,
+		retargetTime:    retargetTime,
+		variancePercent: cfg.VariancePercent,
+	}
+	if options.maxDifficulty <= 0 {
+		options.maxDifficulty = math.MaxFloat64
+	}
+	if options.minDifficulty > options.maxDifficulty {
+		m := "vardiff: min_difficulty %v is above max_difficulty %v"
+		return nil, fmt.Errorf(m, options.minDifficulty, options.maxDifficulty)
+	}
+
+	return options, nil
+}
+
+func (o *varDiffOptions) clamp(difficulty float64) float64 {
+	if difficulty < o.minDifficulty {
+		return o.minDifficulty
+	}
+	if difficulty > o.maxDifficulty {
+		return o.maxDifficulty
+	}
+	return difficulty
+}
+
+type varDiff struct {
+	options      *varDiffOptions
+	lastShare    time.Time
+	lastRetarget time.Time
+	intervals    []time.Duration
+}
+
+func newVarDiff(options *varDiffOptions) *varDiff {
+	bufferSize := int(options.retargetTime/options.targetTime) * 4
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	return &varDiff{
+		options:   options,
+		intervals: make([]time.Duration, 0, bufferSize),
+	}
+}
+
+// Called with every job sent, only the first one counts
+func (v *varDiff) start(now time.Time) {
+	if v.lastShare.IsZero() {
+		v.lastShare = now
+		v.lastRetarget = now
+	}
+}
+
+// Returns a new difficulty when the session is due for a retarget
+func (v *varDiff) recordShare(now time.Time, difficulty float64) (float64, bool) {
+	if v.lastShare.IsZero() {
+		v.start(now)
+		return difficulty, false
+	}
+
+	if len(v.intervals) == cap(v.intervals) {
+		copy(v.intervals, v.intervals[1:])
+		v.intervals = v.intervals[:len(v.intervals)-1]
+	}
+	v.intervals = append(v.intervals, now.Sub(v.lastShare))
+	v.lastShare = now
+
+	if now.Sub(v.lastRetarget) < v.options.retargetTime {
+		return difficulty, false
+	}
+	v.lastRetarget = now
+
+	var sum time.Duration
+	for _, interval := range v.intervals {
+		sum += interval
+	}
+	average := float64(sum) / float64(len(v.intervals))
+	if average <= 0 {
+		average = 1
+	}
+
+	target := float64(v.options.targetTime)
+	variance := target * v.options.variancePercent / 100
+	if average >= target-variance && average <= target+variance {
+		return difficulty, false
+	}
+
+	newDifficulty := v.options.clamp(difficulty * target / average)
+	if newDifficulty == difficulty {
+		return difficulty, false
+	}
+	v.intervals = v.intervals[:0]
+
+	return newDifficulty, true
+}
+
+// Returns a lower difficulty when no share arrived within the retarget time.
+// The time since the last share is the shortest the next interval can be.
+func (v *varDiff) idleRetarget(now time.Time, difficulty float64) (float64, bool) {
+	if v.lastShare.IsZero() {
+		return difficulty, false
+	}
+	idle := now.Sub(v.lastShare)
+	if idle < v.options.retargetTime || now.Sub(v.lastRetarget) < v.options.retargetTime {
+		return difficulty, false
+	}
+
+	newDifficulty := v.options.clamp(difficulty * float64(v.options.targetTime) / float64(idle))
+	if newDifficulty >= difficulty {
+		return difficulty, false
+	}
+	v.lastRetarget = now
+	v.lastShare = now // Measure the lower difficulty on its own
+	v.intervals = v.intervals[:0]
+
+	return newDifficulty, true
+}
+
+// mining.suggest_difficulty or d=X in the password.
+// Before authorization it only seeds the session's starting difficulty.
+func (client *stratumClient) suggestDifficulty(difficulty float64) {
+	client.Lock()
+	defer client.Unlock()
+
+	client.suggestedDifficulty = difficulty
+	if client.login != "" {
+		client.pendingDifficulty = client.floorDifficulty(difficulty)
+	}
+}
+
+// Queues a difficulty to be sent along with the session's next job
+func (client *stratumClient) setDifficulty(difficulty float64) {
+	client.Lock()
+	defer client.Unlock()
+	client.pendingDifficulty = client.floorDifficulty(difficulty)
+}
+
+// BIP310 minimum-difficulty; never go below what the miner asked for
+func (client *stratumClient) setMinimumDifficulty(difficulty float64) {
+	client.Lock()
+	defer client.Unlock()
+
+	client.minimumDifficulty = difficulty
+	if client.pendingDifficulty > 0 {
+		client.pendingDifficulty = client.floorDifficulty(client.pendingDifficulty)
+	} else if client.difficulty > 0 && client.difficulty < difficulty {
+		client.pendingDifficulty = difficulty
+	}
+}
+
+// Call with the client locked
+func (client *stratumClient) floorDifficulty(difficulty float64) float64 {
+	if difficulty < client.minimumDifficulty {
+		return client.minimumDifficulty
+	}
+	return difficulty
+}
+
+func (client *stratumClient) retarget(now time.Time) {
+	if client.varDiff == nil {
+		return
+	}
+
+	client.Lock()
+	defer client.Unlock()
+
+	current := client.difficulty
+	if client.pendingDifficulty > 0 {
+		current = client.pendingDifficulty
+	}
+
+	newDifficulty, changed := client.varDiff.recordShare(now, current)
+	newDifficulty = client.floorDifficulty(newDifficulty)
+	if !changed || newDifficulty == current {
+		return
+	}
+
+	utils.LogInfof("Retargeting %v [%v] difficulty %v -> %v", client.ip, client.login, current, newDifficulty)
+	client.pendingDifficulty = newDifficulty
+}
+
+// Returns true when the session's difficulty was lowered and it needs a job to apply it
+func (client *stratumClient) retargetIdle(now time.Time) bool {
+	if client.varDiff == nil {
+		return false
+	}
+
+	client.Lock()
+	defer client.Unlock()
+
+	current := client.difficulty
+	if client.pendingDifficulty > 0 {
+		current = client.pendingDifficulty
+	}
+
+	newDifficulty, changed := client.varDiff.idleRetarget(now, current)
+	newDifficulty = client.floorDifficulty(newDifficulty)
+	if !changed || newDifficulty >= current {
+		return false
+	}
+
+	utils.LogInfof("Retargeting idle %v [%v] difficulty %v -> %v", client.ip, client.login, current, newDifficulty)
+	client.pendingDifficulty = newDifficulty
+	return true
+}
+
+const varDiffSweepInterval = time.Second
+
+// Sends the current job again to sessions lowered for not finding shares, so they don't wait for the next block
+func (pool *PoolServer) retargetIdleSessions() {
+	for range time.Tick(varDiffSweepInterval) {
+		now := time.Now()
+		for _, client := range pool.sessions.all() {
+			if !client.retargetIdle(now) {
+				continue
+			}
+
+			work, err := pool.generateWorkFromCache(false)
+			if err != nil {
+				logOnError(err)
+				break
+			}
+			request, err := client.miningNotify(work)
+			if err != nil {
+				logOnError(err)
+				continue
+			}
+			logOnError(sendPacket(request, client))
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package pool
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"designs.capital/dogepool/bitcoin"
+	"designs.capital/dogepool/config"
+)
+
+// 10s between shares, retargeted at most every 30s, within 30%
+func testVarDiffOptions(t *testing.T, minDifficulty, maxDifficulty float64) *varDiffOptions {
+	t.Helper()
+
+	options, err := makeVarDiffOptions(config.VarDiffConfig{
+		MinDifficulty:   minDifficulty,
+		MaxDifficulty:   maxDifficulty,
+		TargetTime:      "10s",
+		RetargetTime:    "30s",
+		VariancePercent: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
+func TestMakeVarDiffOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.VarDiffConfig
+		valid bool
+	}{
+		{"disabled", config.VarDiffConfig{}, true},
+		{"complete", config.VarDiffConfig{MinDifficulty: 8, MaxDifficulty: 64, TargetTime: "10s", RetargetTime: "90s"}, true},
+		{"no max", config.VarDiffConfig{MinDifficulty: 8, TargetTime: "10s", RetargetTime: "90s"}, true},
+		{"min equals max", config.VarDiffConfig{MinDifficulty: 8, MaxDifficulty: 8, TargetTime: "10s", RetargetTime: "90s"}, true},
+		{"no retarget time", config.VarDiffConfig{TargetTime: "10s"}, false},
+		{"bad target time", config.VarDiffConfig{TargetTime: "10", RetargetTime: "90s"}, false},
+		{"bad retarget time", config.VarDiffConfig{TargetTime: "10s", RetargetTime: "soon"}, false},
+		{"zero target time", config.VarDiffConfig{TargetTime: "0s", RetargetTime: "90s"}, false},
+		{"negative retarget time", config.VarDiffConfig{TargetTime: "10s", RetargetTime: "-90s"}, false},
+		{"min above max", config.VarDiffConfig{MinDifficulty: 64, MaxDifficulty: 8, TargetTime: "10s", RetargetTime: "90s"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := makeVarDiffOptions(test.cfg)
+			if test.valid != (err == nil) {
+				t.Fatalf("got error %v", err)
+			}
+			if err != nil && options != nil {
+				t.Fatal("options along with an error")
+			}
+		})
+	}
+
+	options, _ := makeVarDiffOptions(config.VarDiffConfig{TargetTime: "10s", RetargetTime: "90s"})
+	if options.maxDifficulty != math.MaxFloat64 {
+		t.Fatalf("max difficulty without a max_difficulty: %v", options.maxDifficulty)
+	}
+}
+
+func TestVarDiffClamp(t *testing.T) {
+	options := testVarDiffOptions(t, 4, 20)
+	for _, test := range []struct{ difficulty, want float64 }{
+		{1, 4}, {4, 4}, {10, 10}, {20, 20}, {30, 20},
+	} {
+		if got := options.clamp(test.difficulty); got != test.want {
+			t.Fatalf("clamp(%v): got %v, want %v", test.difficulty, got, test.want)
+		}
+	}
+
+	unbounded := testVarDiffOptions(t, 0, 0)
+	if got := unbounded.clamp(1e12); got != 1e12 {
+		t.Fatalf("clamp without bounds: got %v", got)
+	}
+}
+
+func TestVarDiffRecordShare(t *testing.T) {
+	tests := []struct {
+		name          string
+		minDifficulty float64
+		maxDifficulty float64
+		interval      time.Duration
+		shares        int
+		want          float64 // At the last share
+		changed       bool
+	}{
+		{"fast shares", 0, 0, 2 * time.Second, 15, 40, true},
+		{"on target", 0, 0, 10 * time.Second, 3, 8, false},
+		{"within variance", 0, 0, 12 * time.Second, 3, 8, false},
+		{"outside variance", 0, 0, 14 * time.Second, 3, 8 * 10.0 / 14, true},
+		{"above max", 4, 20, time.Second, 30, 20, true},
+		{"below min", 4, 20, time.Minute, 1, 4, true},
+		{"slow first share", 0, 0, 40 * time.Second, 1, 2, true}, // The clock started with the job
+		{"already at max", 4, 8, time.Second, 30, 8, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := newVarDiff(testVarDiffOptions(t, test.minDifficulty, test.maxDifficulty))
+			now := time.Unix(1700000000, 0)
+			v.start(now)
+
+			for i := 1; i <= test.shares; i++ {
+				now = now.Add(test.interval)
+				difficulty, changed := v.recordShare(now, 8)
+				if i < test.shares {
+					if changed {
+						t.Fatalf("share %v retargeted to %v before the retarget time", i, difficulty)
+					}
+					continue
+				}
+				if changed != test.changed || difficulty != test.want {
+					t.Fatalf("got %v (%v), want %v (%v)", difficulty, changed, test.want, test.changed)
+				}
+			}
+		})
+	}
+}
+
+func TestVarDiffFirstShareWithoutJobStartsTheClock(t *testing.T) {
+	v := newVarDiff(testVarDiffOptions(t, 0, 0))
+	now := time.Unix(1700000000, 0)
+
+	difficulty, changed := v.recordShare(now, 8)
+	if changed || difficulty != 8 {
+		t.Fatalf("got %v (%v)", difficulty, changed)
+	}
+	if !v.lastShare.Equal(now) {
+		t.Fatal("the first share didn't start the clock")
+	}
+}
+
+func TestVarDiffIdleRetarget(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	v := newVarDiff(testVarDiffOptions(t, 1000, 0))
+	if _, changed := v.idleRetarget(at(60), 8192); changed {
+		t.Fatal("retargeted before any job was sent")
+	}
+	v.start(start)
+
+	steps := []struct {
+		seconds    int
+		difficulty float64
+		want       float64
+		changed    bool
+	}{
+		{29, 8192, 8192, false},
+		{30, 8192, 8192 * 10.0 / 30, true},
+		{45, 8192 * 10.0 / 30, 8192 * 10.0 / 30, false}, // Not due yet
+		{60, 8192 * 10.0 / 30, 1000, true},              // 910 below min
+		{90, 1000, 1000, false},                         // Already at min
+	}
+	for _, step := range steps {
+		difficulty, changed := v.idleRetarget(at(step.seconds), step.difficulty)
+		if changed != step.changed || difficulty != step.want {
+			t.Fatalf("after %vs: got %v (%v), want %v (%v)", step.seconds, difficulty, changed, step.want, step.changed)
+		}
+	}
+
+	// Shares keep a session from being idle
+	v = newVarDiff(testVarDiffOptions(t, 0, 0))
+	v.start(start)
+	v.recordShare(at(20), 8192)
+	if _, changed := v.idleRetarget(at(30), 8192); changed {
+		t.Fatal("retargeted 10s after a share")
+	}
+
+	// Idle never raises, even when retargeting more often than the target time
+	options := testVarDiffOptions(t, 0, 0)
+	options.retargetTime = 5 * time.Second
+	v = newVarDiff(options)
+	v.start(start)
+	if difficulty, changed := v.idleRetarget(at(6), 8); changed {
+		t.Fatalf("idle for less than the target time raised the difficulty to %v", difficulty)
+	}
+}
+
+func TestSessionIdleRetarget(t *testing.T) {
+	client := &stratumClient{
+		ip:                "192.0.2.1",
+		varDiff:           newVarDiff(testVarDiffOptions(t, 0, 0)),
+		pendingDifficulty: 8192,
+		minimumDifficulty: 5000, // mining.configure's minimum-difficulty
+		outbound:          make(chan []byte, outboundQueueSize),
+	}
+
+	start := time.Now()
+	if _, err := client.miningNotify(bitcoin.Work{"1a"}); err != nil {
+		t.Fatal(err)
+	}
+	if client.varDiff.lastShare.Before(start) {
+		t.Fatal("the first job didn't start the clock")
+	}
+	if client.difficulty != 8192 {
+		t.Fatalf("difficulty %v sent with the first job", client.difficulty)
+	}
+
+	if client.retargetIdle(start.Add(10 * time.Second)) {
+		t.Fatal("retargeted before the retarget time")
+	}
+	if !client.retargetIdle(start.Add(31 * time.Second)) {
+		t.Fatal("no share for 31s, but the difficulty stayed")
+	}
+	if client.pendingDifficulty != 5000 {
+		t.Fatalf("pending difficulty %v, want the miner's minimum 5000", client.pendingDifficulty)
+	}
+	if client.retargetIdle(start.Add(time.Minute + 2*time.Second)) {
+		t.Fatal("retargeted below the miner's minimum")
+	}
+}
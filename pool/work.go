@@ -5,8 +5,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"designs.capital/dogepool/bitcoin"
@@ -19,7 +19,7 @@ const magic = "\xfa\xbe\x6d\x6d"
 
 // Function to create the coinbase transaction for merged mining
 func createMergedMiningCoinbase(auxblocks []*bitcoin.AuxBlock, merkleNonce int32) (string, error) {
-	merkleSize := 16
+	merkleSize := bitcoin.MergedMiningTreeSize
 	// merkleSize := len(auxblocks)
 	// if merkleSize%2 == 1 { // Assume merkle_size is a power of 2
 	// 	merkleSize = merkleSize + 1
@@ -67,7 +67,8 @@ func buildMerkleRoot(merkleSize int, auxblocks []*bitcoin.AuxBlock) ([]byte, err
 	return currentLevel[0], nil
 }
 
-// Create the coinbase scriptSig
+// Create the coinbase scriptSig: magic, root, size and nonce.
+// No parent chain ID, the aux chains' own IDs only pick their slots in the tree.
 func createScriptSig(merkleNonce, merkleSize int32, merkleRoot []byte) []byte {
 	scriptSig := make([]byte, 0)
 	scriptSig = append(scriptSig, magic...)
@@ -75,8 +76,6 @@ func createScriptSig(merkleNonce, merkleSize int32, merkleRoot []byte) []byte {
 	scriptSig = append(scriptSig, reversedMerkleRoot...)
 	scriptSig = append(scriptSig, int32ToBytes(merkleSize)...)
 	scriptSig = append(scriptSig, int32ToBytes(merkleNonce)...)
-	// parentChainId, _ := hex.DecodeString("00002632")
-	// scriptSig = append(scriptSig, parentChainId...)
 	return scriptSig
 }
 
@@ -87,22 +86,264 @@ func int32ToBytes(i int32) []byte {
 	return b
 }
 
+// Jobs handed out to miners, so shares are checked against the block they were mined on.
+// Refreshes, aux blocks and reissues add jobs on the current tip, so those are bounded apart from stale ones.
+const (
+	maxFreshJobs = 256
+	maxStaleJobs = 16
+)
+
+type job struct {
+	sync.Mutex
+	block       bitcoin.BitcoinBlock
+	auxBlocks   []*bitcoin.AuxBlock
+	created     time.Time
+	staleSince  time.Time           // Set once a clean job replaces this one
+	submissions map[string]struct{} // Dropped along with the job
+}
+
+func (j *job) isStale() bool {
+	j.Lock()
+	defer j.Unlock()
+	return !j.staleSince.IsZero()
+}
+
+func (j *job) markStale(now time.Time) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.staleSince.IsZero() {
+		j.staleSince = now
+	}
+}
+
+// Stale jobs still earn pool credit within the grace period, but never submit blocks
+func (j *job) staleness(now time.Time, grace time.Duration) (stale, expired bool) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.staleSince.IsZero() {
+		return false, false
+	}
+	return true, now.Sub(j.staleSince) > grace
+}
+
+// Returns false if this exact share was already submitted for the job
+func (j *job) recordSubmission(extranonce1, extranonce2, nonceTime, nonce string, version uint) bool {
+	key := strings.ToLower(extranonce1+extranonce2+nonceTime+nonce) + fmt.Sprintf("%08x", version) // Rolled versions are different headers
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.submissions == nil {
+		j.submissions = make(map[string]struct{})
+	}
+	if _, exists := j.submissions[key]; exists {
+		return false
+	}
+	j.submissions[key] = struct{}{}
+
+	return true
+}
+
+type jobRegistry struct {
+	sync.RWMutex
+	jobs  map[string]*job
+	order []string // Oldest first
+}
+
+// Jobs building on another previous block than the new job are stale, however the new job was announced
+func (r *jobRegistry) add(jobID string, newJob *job) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.jobs == nil {
+		r.jobs = make(map[string]*job)
+	}
+	tip := newJob.block.Template.PrevBlockHash
+	for _, oldJob := range r.jobs {
+		if !strings.EqualFold(oldJob.block.Template.PrevBlockHash, tip) {
+			oldJob.markStale(newJob.created)
+		}
+	}
+
+	r.jobs[jobID] = newJob
+	r.order = append(r.order, jobID)
+
+	r.evict()
+}
+
+// Oldest first, stale jobs never push out jobs on the current tip.
+// Call with the registry locked.
+func (r *jobRegistry) evict() {
+	var fresh, stale []string
+	for _, jobID := range r.order {
+		if r.jobs[jobID].isStale() {
+			stale = append(stale, jobID)
+		} else {
+			fresh = append(fresh, jobID)
+		}
+	}
+	if len(fresh) <= maxFreshJobs && len(stale) <= maxStaleJobs {
+		return
+	}
+
+	for ; len(fresh) > maxFreshJobs; fresh = fresh[1:] {
+		delete(r.jobs, fresh[0])
+	}
+	for ; len(stale) > maxStaleJobs; stale = stale[1:] {
+		delete(r.jobs, stale[0])
+	}
+
+	order := r.order[:0]
+	for _, jobID := range r.order {
+		if _, exists := r.jobs[jobID]; exists {
+			order = append(order, jobID)
+		}
+	}
+	r.order = order
+}
+
+func (r *jobRegistry) get(jobID string) (*job, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	j, exists := r.jobs[jobID]
+	return j, exists
+}
+
 // Main INPUT
-func (p *PoolServer) fetchRpcBlockTemplatesAndCacheWork() error {
-	var block *bitcoin.BitcoinBlock
-	var err error
-	template, auxblocks, err := p.fetchAllBlockTemplatesFromRPC()
+// Returns true when the new work builds on another block than the previous work, so miners should drop their jobs.
+func (p *PoolServer) fetchRpcBlockTemplatesAndCacheWork() (bool, error) {
+	p.workLock.Lock()
+	defer p.workLock.Unlock()
+
+	template, auxblocks, err := p.fetchBlockTemplatesWithRecovery()
+	if err != nil {
+		return false, err
+	}
+
+	clean := !p.cachedTemplateBuildsOn(template.PrevBlockHash)
+	return clean, p.cacheWork(template, auxblocks)
+}
+
+// Whether the notified block is already the cached work's previous block
+func (p *PoolServer) workBuildsOn(blockHash string) bool {
+	p.workLock.Lock()
+	defer p.workLock.Unlock()
+	return p.cachedTemplateBuildsOn(blockHash)
+}
+
+// Call with the work lock held
+func (p *PoolServer) cachedTemplateBuildsOn(blockHash string) bool {
+	previous := p.templates.BitcoinBlock.Template
+	return previous != nil && strings.EqualFold(previous.PrevBlockHash, blockHash)
+}
+
+// Between blocks, only new transactions or fees are worth a new job.
+// Broadcasts under the work lock so a refresh can't overtake a newer block's work.
+func (p *PoolServer) refreshRpcBlockTemplatesAndBroadcastWork() error {
+	p.workLock.Lock()
+	defer p.workLock.Unlock()
+
+	template, auxblocks, err := p.fetchBlockTemplatesWithRecovery()
+	if err != nil {
+		return err
+	}
+
+	previous := p.templates.BitcoinBlock.Template
+	clean := !p.cachedTemplateBuildsOn(template.PrevBlockHash) // We missed a block notification
+	if !clean && !template.ContentChanged(previous) {
+		return nil
+	}
+
+	err = p.cacheWork(template, auxblocks)
+	if err != nil {
+		return err
+	}
+	work, err := p.generateWorkFromCache(clean)
+	if err != nil {
+		return err
+	}
+	p.broadcastWork(work)
+
+	return nil
+}
+
+// A new aux block only changes the merged mining commitment, so primary jobs already out stay valid
+func (p *PoolServer) refreshAuxBlockAndCacheWork(chainName string) error {
+	p.workLock.Lock()
+	defer p.workLock.Unlock()
+
+	n := -1
+	for i, name := range p.config.BlockChainOrder {
+		if i > 0 && name == chainName {
+			n = i
+		}
+	}
+	if n < 0 {
+		return errors.New("not an aux chain: " + chainName)
+	}
+
+	previous := p.templates.BitcoinBlock.Template
+	if previous == nil || len(p.templates.AuxBlocks) < n {
+		return errors.New("no primary work to refresh " + chainName + " on")
+	}
+
+	auxBlock, err := p.fetchAuxBlockFromRPC(n)
 	if err != nil {
 		// Switch nodes if we fail to get work
 		err = p.CheckAndRecoverRPCs()
 		if err != nil {
 			return err
 		}
-		template, auxblocks, err = p.fetchAllBlockTemplatesFromRPC()
+		auxBlock, err = p.fetchAuxBlockFromRPC(n)
 		if err != nil {
 			return err
 		}
 	}
+
+	auxblocks := make([]*bitcoin.AuxBlock, len(p.templates.AuxBlocks))
+	copy(auxblocks, p.templates.AuxBlocks) // Older jobs keep their own aux blocks
+	auxblocks[n-1] = auxBlock
+
+	return p.cacheWork(*previous, auxblocks)
+}
+
+// The current template under a new job ID, marked clean since it's sent with a new extranonce1.
+// Older jobs stay valid.
+func (p *PoolServer) reissueWork() (bitcoin.Work, error) {
+	p.workLock.Lock()
+	defer p.workLock.Unlock()
+
+	previous := p.templates.BitcoinBlock.Template
+	if previous == nil {
+		return nil, errors.New("no work to reissue")
+	}
+
+	err := p.cacheWork(*previous, p.templates.AuxBlocks)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.generateWorkFromCache(true)
+}
+
+func (p *PoolServer) fetchBlockTemplatesWithRecovery() (bitcoin.Template, []*bitcoin.AuxBlock, error) {
+	template, auxblocks, err := p.fetchAllBlockTemplatesFromRPC()
+	if err != nil {
+		// Switch nodes if we fail to get work
+		err = p.CheckAndRecoverRPCs()
+		if err != nil {
+			return template, nil, err
+		}
+		return p.fetchAllBlockTemplatesFromRPC()
+	}
+	return template, auxblocks, nil
+}
+
+// Call with the work lock held
+func (p *PoolServer) cacheWork(template bitcoin.Template, auxblocks []*bitcoin.AuxBlock) error {
 	// utils.LogInfof("%+v, %+v, %+v", template, auxblock, aux2block)
 	p.templates.AuxBlocks = auxblocks
 	auxillary := p.config.BlockSignature
@@ -116,83 +357,138 @@ func (p *PoolServer) fetchRpcBlockTemplatesAndCacheWork() error {
 	primaryName := p.config.GetPrimary()
 	// TODO this is chain/bitcoin specific
 	rewardPubScriptKey := p.GetPrimaryNode().RewardPubScriptKey
+	recipients := p.GetPrimaryNode().CoinbaseRecipients
 	extranonceByteReservationLength := 8
 
-	block, p.workCache, err = bitcoin.GenerateWork(&template, primaryName, auxillary, rewardPubScriptKey, extranonceByteReservationLength)
+	block, work, err := bitcoin.GenerateWork(&template, primaryName, auxillary, rewardPubScriptKey, recipients, extranonceByteReservationLength)
 	if err != nil {
-		log.Print(err)
+		return err
 	}
 
 	p.templates.BitcoinBlock = *block
 
+	// Registered before it's published, so shares on it always find it
+	jobID := work[0].(string)
+	p.jobs.add(jobID, &job{
+		block:     *block,
+		auxBlocks: auxblocks,
+		created:   time.Now(),
+	})
+
+	p.workCacheLock.Lock()
+	p.workCache = work
+	p.workCacheLock.Unlock()
+
 	return nil
 }
 
 // Main OUTPUT
 func (p *PoolServer) recieveWorkFromClient(share bitcoin.Work, client *stratumClient) error {
-	primaryBlockTemplate := p.templates.GetPrimary()
-	if primaryBlockTemplate.Template == nil {
-		return errors.New("primary block template not yet set")
+	params, err := parseSubmission(share)
+	if err != nil {
+		return err
 	}
 
-	var err error
-
 	// TODO - this key and interface isn't very invertable..
-	workerString := share[0].(string)
+	workerString := params[0]
+	if !client.isAuthorized(workerString) {
+		m := "Share from unauthorized worker %v on %v [%v]"
+		utils.LogInfof(m, workerString, client.ip, client.login)
+		return errUnauthorized
+	}
 	workerStringParts := strings.Split(workerString, ".")
 	if len(workerStringParts) < 2 {
-		return errors.New("invalid miner address")
+		return errUnauthorized
 	}
 	minerAddress := workerStringParts[0]
 	rigID := workerStringParts[1]
 
+	jobID := params[1]
+	shareJob, exists := p.jobs.get(jobID)
+	if !exists {
+		m := "Share for unknown job %v from %v [%v]"
+		utils.LogInfof(m, jobID, client.ip, rigID)
+		return errJobNotFound
+	}
+
+	stale, expired := shareJob.staleness(time.Now(), p.staleShareGrace)
+	if expired {
+		m := "Stale share for job %v from %v [%v]"
+		utils.LogInfof(m, jobID, client.ip, rigID)
+
+		// Recorded without credit so stale rates count it
+		p.bufferShare(&shareJob.block, minerAddress, rigID, client, 0, persistence.ShareStatusStale)
+		return errJobNotFound
+	}
+
+	primaryBlockTemplate := shareJob.block
+	err = validateSubmission(&primaryBlockTemplate, params)
+	if err != nil {
+		m := "Rejected share for job %v from %v [%v]: %v %v"
+		utils.LogInfof(m, jobID, client.ip, rigID, err, params)
+		return err
+	}
+
+	client.Lock()
+	versionRollingMask := client.versionRollingMask
+	client.Unlock()
+	version, err := submittedVersion(&primaryBlockTemplate, params, versionRollingMask)
+	if err != nil {
+		m := "Rejected share for job %v from %v [%v]: %v %v"
+		utils.LogInfof(m, jobID, client.ip, rigID, err, params)
+		return err
+	}
+
 	primaryBlockHeight := primaryBlockTemplate.Template.Height
-	nonce := share[primaryBlockTemplate.NonceSubmissionSlot()].(string)
+	nonce := params[primaryBlockTemplate.NonceSubmissionSlot()]
 	extranonce2Slot, _ := primaryBlockTemplate.Extranonce2SubmissionSlot()
-	extranonce2 := share[extranonce2Slot].(string)
-	nonceTime := share[primaryBlockTemplate.NonceTimeSubmissionSlot()].(string)
+	extranonce2 := params[extranonce2Slot]
+	nonceTime := params[primaryBlockTemplate.NonceTimeSubmissionSlot()]
+
+	sent := client.sentJob(jobID)
 
-	// TODO - validate input
+	if !shareJob.recordSubmission(sent.extranonce1, extranonce2, nonceTime, nonce, version) {
+		duplicates := client.countDuplicate(workerString)
+		m := "Duplicate share for job %v from %v [%v] (%v duplicates)"
+		utils.LogInfof(m, jobID, client.ip, rigID, duplicates)
+
+		// Recorded without credit so duplicate rates count it against the worker
+		p.bufferShare(&primaryBlockTemplate, minerAddress, rigID, client, 0, persistence.ShareStatusDuplicate)
+		return errDuplicateShare
+	}
 
-	extranonce := client.extranonce1 + extranonce2
+	extranonce := sent.extranonce1 + extranonce2
 
-	_, err = primaryBlockTemplate.MakeHeader(extranonce, nonce, nonceTime)
+	_, err = primaryBlockTemplate.MakeHeader(extranonce, nonce, nonceTime, version)
 
 	if err != nil {
 		return err
 	}
 
-	shareStatus, candidate, shareDifficulty := validateAndWeighShare(&primaryBlockTemplate, p.config.PoolDifficulty)
+	jobDifficulty := sent.difficulty
+	shareStatus, candidate, shareDifficulty := validateAndWeighShare(&primaryBlockTemplate, jobDifficulty)
 
 	if shareStatus == shareInvalid {
 		m := "❔ Invalid share for block %v from %v [%v] [%v] [%v/%v]"
-		m = fmt.Sprintf(m, primaryBlockHeight, client.ip, rigID, client.userAgent, shareDifficulty, p.config.PoolDifficulty)
-		return errors.New(m)
+		utils.LogInfof(m, primaryBlockHeight, client.ip, rigID, client.userAgent, shareDifficulty, jobDifficulty)
+		return errLowDifficulty
 	}
 
+	status := persistence.ShareStatusValid
 	m := "Valid share for block %v from %v [%v] [%v/%v]"
-	m = fmt.Sprintf(m, primaryBlockHeight, client.ip, rigID, shareDifficulty, p.config.PoolDifficulty)
+	if stale {
+		status = persistence.ShareStatusStale
+		m = "Stale share within grace period for block %v from %v [%v] [%v/%v]"
+	}
+	m = fmt.Sprintf(m, primaryBlockHeight, client.ip, rigID, shareDifficulty, jobDifficulty)
 	utils.LogInfo(m)
 
-	blockTarget := bitcoin.Target(primaryBlockTemplate.Template.Target)
-	blockDifficulty, _ := blockTarget.ToDifficulty()
-	blockDifficulty = blockDifficulty * primaryBlockTemplate.ShareMultiplier()
+	client.retarget(time.Now())
 
-	p.Lock()
-	p.shareBuffer = append(p.shareBuffer, persistence.Share{
-		PoolID:            p.config.PoolName,
-		BlockHeight:       primaryBlockHeight,
-		Miner:             minerAddress,
-		Worker:            rigID,
-		UserAgent:         client.userAgent,
-		Difficulty:        shareDifficulty,
-		NetworkDifficulty: blockDifficulty,
-		IpAddress:         client.ip,
-		Created:           time.Now(),
-	})
-	p.Unlock()
+	p.bufferShare(&primaryBlockTemplate, minerAddress, rigID, client, shareDifficulty, status)
+	blockDifficulty := networkDifficulty(&primaryBlockTemplate)
 
-	if shareStatus == shareValid {
+	if shareStatus == shareValid || stale {
 		return nil
 	}
 	nbCandidate := 0
@@ -226,7 +522,9 @@ func (p *PoolServer) recieveWorkFromClient(share bitcoin.Work, client *stratumCl
 		// Try to submit on different node
 		// err = p.rpcManagers[p.config.GetPrimary()].CheckAndRecoverRPCs()
 		if err != nil {
-			return err
+			// The share itself was fine, only the block was rejected
+			utils.LogError(err)
+			return nil
 		}
 		// err = p.submitBlockToChain(primaryBlockTemplate)
 		// }
@@ -258,7 +556,7 @@ func (p *PoolServer) recieveWorkFromClient(share bitcoin.Work, client *stratumCl
 		if i == 0 {
 			continue
 		}
-		auxBlock := p.templates.GetAuxN(i)
+		auxBlock := shareJob.auxBlocks[i-1]
 		if candidate[i] {
 			err = p.submitAuxBlock(i, primaryBlockTemplate)
 			if err != nil {
@@ -268,7 +566,12 @@ func (p *PoolServer) recieveWorkFromClient(share bitcoin.Work, client *stratumCl
 				// EnrichShare
 				aux1Target := bitcoin.Target(reverseHexBytes(auxBlock.Target))
 				auxDifficulty, _ := aux1Target.ToDifficulty()
-				auxDifficulty = auxDifficulty * bitcoin.GetChain(chainName).ShareMultiplier()
+				auxChain, err := bitcoin.GetChain(chainName)
+				if err != nil {
+					utils.LogError(err)
+				} else {
+					auxDifficulty = auxDifficulty * auxChain.ShareMultiplier()
+				}
 
 				found.Chain = chainName
 				found.Created = time.Now()
@@ -297,8 +600,41 @@ func (p *PoolServer) recieveWorkFromClient(share bitcoin.Work, client *stratumCl
 	return nil
 }
 
+func networkDifficulty(block *bitcoin.BitcoinBlock) float64 {
+	blockTarget := bitcoin.Target(block.Template.Target)
+	blockDifficulty, _ := blockTarget.ToDifficulty()
+	return blockDifficulty * block.ShareMultiplier()
+}
+
+func (p *PoolServer) bufferShare(block *bitcoin.BitcoinBlock, minerAddress, rigID string, client *stratumClient, difficulty float64, status string) {
+	p.Lock()
+	p.shareBuffer = append(p.shareBuffer, persistence.Share{
+		PoolID:            p.config.PoolName,
+		BlockHeight:       block.Template.Height,
+		Miner:             minerAddress,
+		Worker:            rigID,
+		UserAgent:         client.userAgent,
+		Difficulty:        difficulty,
+		NetworkDifficulty: networkDifficulty(block),
+		IpAddress:         client.ip,
+		Status:            status,
+		Created:           time.Now(),
+	})
+	p.Unlock()
+}
+
 func (pool *PoolServer) generateWorkFromCache(refresh bool) (bitcoin.Work, error) {
-	work := append(pool.workCache, interface{}(refresh))
+	pool.workCacheLock.RLock()
+	defer pool.workCacheLock.RUnlock()
+
+	if pool.workCache == nil {
+		return nil, errors.New("no work cached yet")
+	}
+
+	// A copy, appending to the cache could share its backing array
+	work := make(bitcoin.Work, 0, len(pool.workCache)+1)
+	work = append(work, pool.workCache...)
+	work = append(work, interface{}(refresh))
 
 	return work, nil
 }
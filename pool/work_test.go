@@ -0,0 +1,136 @@
+package pool
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"designs.capital/dogepool/bitcoin"
+)
+
+func TestDuplicateSharesAreCountedPerWorker(t *testing.T) {
+	shareJob := &job{}
+	client := &stratumClient{}
+
+	submissions := []struct {
+		worker  string
+		version uint
+		want    uint // Duplicates so far, 0 for a new share
+	}{
+		{"address.rig1", 0x20000000, 0},
+		{"address.rig1", 0x20000000, 1},
+		{"address.rig2", 0x20000000, 1},
+		{"address.rig1", 0x20002000, 0}, // Rolled version, another header
+		{"address.rig1", 0x20000000, 2},
+	}
+
+	for i, submission := range submissions {
+		duplicates := uint(0)
+		if !shareJob.recordSubmission("0a0b0c0d", "00000001", "6530c000", "0000abcd", submission.version) {
+			duplicates = client.countDuplicate(submission.worker)
+		}
+		if duplicates != submission.want {
+			t.Fatalf("submission %v from %v: got %v duplicates, want %v", i, submission.worker, duplicates, submission.want)
+		}
+	}
+
+	if client.duplicateShares["address.rig1"] != 2 || client.duplicateShares["address.rig2"] != 1 {
+		t.Fatalf("duplicates: %v", client.duplicateShares)
+	}
+}
+
+const (
+	tipA = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+	tipB = "3b8c1e27a0b4a4e3c1f4c3f5b0c2a3b2b0a5f0e7d7e2b9b0c7b0a1a2a3a4a5a6"
+)
+
+func jobOn(prevBlockHash string, created time.Time) *job {
+	return &job{
+		block:   bitcoin.BitcoinBlock{Template: &bitcoin.Template{PrevBlockHash: prevBlockHash}},
+		created: created,
+	}
+}
+
+func TestJobsGoStaleWhenTheTipMoves(t *testing.T) {
+	var registry jobRegistry
+	now := time.Now()
+
+	registry.add("1", jobOn(tipA, now))
+	registry.add("2", jobOn(tipA, now)) // A refresh, or the late notification for tip A
+	for _, jobID := range []string{"1", "2"} {
+		oldJob, _ := registry.get(jobID)
+		if stale, _ := oldJob.staleness(now, time.Minute); stale {
+			t.Fatalf("job %v on the current tip is stale", jobID)
+		}
+	}
+
+	registry.add("3", jobOn(strings.ToUpper(tipB), now)) // Even when an aux refresh finds the new tip
+	for _, jobID := range []string{"1", "2"} {
+		oldJob, _ := registry.get(jobID)
+		if stale, _ := oldJob.staleness(now, time.Minute); !stale {
+			t.Fatalf("job %v on the previous tip is fresh", jobID)
+		}
+	}
+
+	registry.add("4", jobOn(tipB, now.Add(time.Second)))
+	newJob, _ := registry.get("3")
+	if stale, _ := newJob.staleness(now, time.Minute); stale {
+		t.Fatal("job 3 went stale on its own tip")
+	}
+	oldJob, _ := registry.get("1")
+	if oldJob.staleSince != now {
+		t.Fatalf("job 1 stale since %v, want the first job on the new tip %v", oldJob.staleSince, now)
+	}
+}
+
+func TestBlockNotificationForCachedTip(t *testing.T) {
+	pool := &PoolServer{}
+	if pool.workBuildsOn(tipA) {
+		t.Fatal("no work cached yet, but it builds on the tip")
+	}
+
+	pool.templates.BitcoinBlock.Template = &bitcoin.Template{PrevBlockHash: tipA}
+	if !pool.workBuildsOn(strings.ToUpper(tipA)) {
+		t.Fatal("cached work doesn't build on its own previous block")
+	}
+	if pool.workBuildsOn(tipB) {
+		t.Fatal("cached work builds on another block")
+	}
+}
+
+func TestStaleJobsAreEvictedFirst(t *testing.T) {
+	var registry jobRegistry
+	now := time.Now()
+
+	for i := 0; i < maxStaleJobs+5; i++ {
+		registry.add(fmt.Sprintf("a%v", i), jobOn(tipA, now))
+	}
+	// Refreshes, aux blocks and reissues on the new tip
+	for i := 0; i < maxFreshJobs; i++ {
+		registry.add(fmt.Sprintf("b%v", i), jobOn(tipB, now))
+	}
+
+	for i := 0; i < maxFreshJobs; i++ {
+		if _, exists := registry.get(fmt.Sprintf("b%v", i)); !exists {
+			t.Fatalf("job b%v on the current tip was evicted", i)
+		}
+	}
+	for i := 0; i < maxStaleJobs+5; i++ {
+		_, exists := registry.get(fmt.Sprintf("a%v", i))
+		if want := i >= 5; exists != want {
+			t.Fatalf("stale job a%v kept: %v, want %v", i, exists, want)
+		}
+	}
+
+	registry.add("b-last", jobOn(tipB, now))
+	if _, exists := registry.get("b0"); exists {
+		t.Fatal("oldest job on the current tip kept past the bound")
+	}
+	if _, exists := registry.get("a5"); !exists {
+		t.Fatal("stale job evicted to make room for a job on the current tip")
+	}
+	if len(registry.order) != len(registry.jobs) || len(registry.jobs) != maxFreshJobs+maxStaleJobs {
+		t.Fatalf("%v jobs in order, %v registered", len(registry.order), len(registry.jobs))
+	}
+}
@@ -12,11 +12,14 @@ import (
 )
 
 type RPCClient struct {
-	NodeUrl string
-	Name    string
-	client  *http.Client
+	NodeUrl        string
+	Name           string
+	client         *http.Client
+	longPollClient *http.Client // Long polls are held open by the node until its template changes
 }
 
+const longPollTimeout = 10 * time.Minute
+
 func NewRPCClient(name, rpcURL, rpcUser, rpcPassword, timeout string) *RPCClient {
 	urlParts := strings.Split(rpcURL, "://")
 	rpcClient := &RPCClient{
@@ -31,6 +34,9 @@ func NewRPCClient(name, rpcURL, rpcUser, rpcPassword, timeout string) *RPCClient
 	rpcClient.client = &http.Client{
 		Timeout: timeOutIntv,
 	}
+	rpcClient.longPollClient = &http.Client{
+		Timeout: longPollTimeout,
+	}
 
 	return rpcClient
 }
@@ -47,6 +53,10 @@ type rpcError struct {
 }
 
 func (r *RPCClient) doRequest(method string, params []interface{}) (rpcResponse, int, error) {
+	return r.doRequestWithClient(r.client, method, params)
+}
+
+func (r *RPCClient) doRequestWithClient(client *http.Client, method string, params []interface{}) (rpcResponse, int, error) {
 	type rpcRequest struct {
 		ID             int           `json:"id"`
 		JsonRPCVersion string        `json:"jsonrpc"`
@@ -77,7 +87,7 @@ func (r *RPCClient) doRequest(method string, params []interface{}) (rpcResponse,
 		req.Header.Add("Content-Type", "application/json")
 	}
 
-	resp, err := r.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return rpcResp, 0, err
 	}
@@ -123,6 +133,36 @@ func (r *RPCClient) GetBlockTemplate() (json.RawMessage, error) {
 	return resp.Result, nil
 }
 
+type LongPollReply struct {
+	LongPollID        string `json:"longpollid"`
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+// Blocks until the node's template no longer matches longPollID.  An empty ID returns right away.
+func (r *RPCClient) GetBlockTemplateLongPoll(longPollID string) (LongPollReply, error) {
+	var reply LongPollReply
+
+	request := make(map[string]any)
+	request["rules"] = []string{"mweb", "segwit"}
+	if longPollID != "" {
+		request["longpollid"] = longPollID
+	}
+	params := make([]interface{}, 1)
+	params[0] = request
+
+	resp, status, err := r.doRequestWithClient(r.longPollClient, "getblocktemplate", params)
+	if err != nil {
+		return reply, err
+	}
+
+	if status != 200 {
+		return reply, handleHttpError(resp, status)
+	}
+
+	err = json.Unmarshal(resp.Result, &reply)
+	return reply, err
+}
+
 func (r *RPCClient) CreateAuxBlock(rewardAddress string) (json.RawMessage, error) {
 	params := make([]any, 1)
 	params[0] = rewardAddress
@@ -179,6 +219,22 @@ func (r *RPCClient) GetLatestBlock() (GetBlockReplyPart, error) {
 	return reply, nil
 }
 
+func (r *RPCClient) GetBestBlockHash() (string, error) {
+	var blockHash string
+
+	resp, status, err := r.doRequest("getbestblockhash", nil)
+	if err != nil {
+		return blockHash, err
+	}
+
+	if status != 200 {
+		return blockHash, handleHttpError(resp, status)
+	}
+
+	err = json.Unmarshal(resp.Result, &blockHash)
+	return blockHash, err
+}
+
 func (r *RPCClient) GetBlockByHash(hash string) (*GetBlockReply, error) {
 	var reply GetBlockReply
 	params := make([]interface{}, 1)